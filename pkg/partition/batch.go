@@ -0,0 +1,216 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"sync"
+)
+
+// Item is a single request within a batch.
+type Item struct {
+	Hash  uint64
+	Input []byte
+}
+
+// BatchRunner is optionally implemented by the clients returned by
+// Network.DialClient and by handlers to execute many requests in a
+// single call.
+//
+// The results must be in the same order as the items. The error is
+// only used for failures which affect the whole batch.
+//
+// Clients and handlers which do not implement this interface have
+// each item run separately via Run.
+type BatchRunner interface {
+	RunBatch(ctx context.Context, items []Item) ([]Result, error)
+}
+
+// RunBatch implements Router.RunBatch.
+func (s *state) RunBatch(ctx context.Context, items []Item) ([]Result, error) {
+	results := make([]Result, len(items))
+	pending := make([]int, len(items))
+	for kk := range items {
+		pending[kk] = kk
+	}
+
+	redirects, refresh := map[int]string{}, false
+	for attempt := 1; ; attempt++ {
+		if err := s.runBatch(ctx, items, pending, redirects, results, refresh); err != nil {
+			return nil, err
+		}
+
+		// like Run, items are redirected right away to the owner
+		// known to the endpoint unless they were already redirected
+		retries, next, wait := pending[:0], map[int]string{}, false
+		for _, idx := range pending {
+			e, ok := results[idx].Err.(IncorrectPartitionError)
+			if !ok {
+				continue
+			}
+			s.adopt(e)
+			retries = append(retries, idx)
+			if owner := s.redirectTo(e); owner != "" {
+				next[idx] = owner
+			}
+			wait = wait || next[idx] == "" || redirects[idx] != ""
+		}
+
+		if pending = retries; len(pending) == 0 || attempt >= s.retry.MaxAttempts {
+			return results, nil
+		}
+		if wait && !s.retry.wait(ctx, attempt) {
+			return results, nil
+		}
+		redirects, refresh = next, refresh || wait
+	}
+}
+
+// runBatch groups the pending items by their owner (or the address
+// they are redirected to) and sends one batch per owner, updating
+// results in place.
+func (s *state) runBatch(ctx context.Context, items []Item, pending []int, redirects map[int]string, results []Result, refresh bool) error {
+	pick, err := s.addrPicker(ctx, refresh)
+	if err != nil {
+		return err
+	}
+
+	groups := map[string][]int{}
+	for _, idx := range pending {
+		addr := redirects[idx]
+		if addr == "" {
+			addr = pick(items[idx].Hash)
+		}
+		groups[addr] = append(groups[addr], idx)
+	}
+
+	var wg sync.WaitGroup
+	for addr, indices := range groups {
+		wg.Add(1)
+		go func(addr string, indices []int) {
+			defer wg.Done()
+
+			batch := make([]Item, len(indices))
+			for kk, idx := range indices {
+				batch[kk] = items[idx]
+			}
+			for kk, result := range s.runBatchOn(ctx, addr, batch) {
+				results[indices[kk]] = result
+			}
+		}(addr, indices)
+	}
+	wg.Wait()
+	return nil
+}
+
+// runBatchOn runs the batch on the specific endpoint.
+func (s *state) runBatchOn(ctx context.Context, addr string, items []Item) []Result {
+	var r Runner = safe{s}
+	if !s.isLocal(addr) {
+		c, release, err := s.client(ctx, addr)
+		if err != nil {
+			return failAll(items, err)
+		}
+		defer release()
+		r, ctx = c, s.withEpoch(ctx)
+	}
+
+	s.loads.start(addr)
+	defer s.loads.done(addr)
+	return runItems(ctx, r, items)
+}
+
+// runItems uses RunBatch if the runner supports it and runs the
+// items concurrently otherwise.
+func runItems(ctx context.Context, r Runner, items []Item) []Result {
+	if b, ok := r.(BatchRunner); ok {
+		results, err := b.RunBatch(ctx, items)
+		if err == nil && len(results) != len(items) {
+			err = errBatchMismatch
+		}
+		if err != nil {
+			return failAll(items, err)
+		}
+		return results
+	}
+
+	var wg sync.WaitGroup
+	results := make([]Result, len(items))
+	for kk := range items {
+		wg.Add(1)
+		go func(kk int) {
+			defer wg.Done()
+			output, err := r.Run(ctx, items[kk].Hash, items[kk].Input)
+			results[kk] = Result{output, err}
+		}(kk)
+	}
+	wg.Wait()
+	return results
+}
+
+func failAll(items []Item, err error) []Result {
+	results := make([]Result, len(items))
+	for kk := range results {
+		results[kk].Err = err
+	}
+	return results
+}
+
+// RunBatch checks the ownership of each item before running the
+// owned items on the handler.
+func (s safe) RunBatch(ctx context.Context, items []Item) ([]Result, error) {
+	ctx, h := receive(ctx)
+	if !s.enter() {
+		return failAll(items, IncorrectPartitionError{}), nil
+	}
+	defer s.exit()
+
+	results := make([]Result, len(items))
+	misrouted := map[int]error{}
+	for kk, item := range items {
+		if err := s.check(ctx, h, item.Hash); err != nil {
+			misrouted[kk] = err
+		}
+	}
+	s.forwardBatch(ctx, h, items, misrouted, results)
+
+	leased := s.leaseStore != nil && !h.broadcast
+	owned, indices := []Item(nil), []int(nil)
+	for kk, item := range items {
+		if _, ok := misrouted[kk]; ok {
+			continue
+		}
+		// runOwned takes care of handoffs when leases are used
+		if !leased {
+			if handed, output, err := s.runOnPrevious(ctx, h, item.Hash, item.Input); handed {
+				results[kk] = Result{output, err}
+				continue
+			}
+		}
+		owned = append(owned, item)
+		indices = append(indices, kk)
+	}
+
+	var r Runner = s.handler
+	if leased {
+		// every item has its own lease deadline and fencing token
+		r = runFunc(func(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+			return s.runOwned(ctx, h, hash, input)
+		})
+	} else if len(owned) > 0 {
+		defer s.handle()()
+	}
+	for kk, result := range runItems(ctx, r, owned) {
+		results[indices[kk]] = result
+	}
+	return results, nil
+}
+
+// runFunc adapts a function to a Runner
+type runFunc func(ctx context.Context, hash uint64, input []byte) ([]byte, error)
+
+func (r runFunc) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+	return r(ctx, hash, input)
+}
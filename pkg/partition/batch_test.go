@@ -0,0 +1,93 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/tvastar/cluster/pkg/partition"
+)
+
+func TestRunBatch(t *testing.T) {
+	ctx := context.Background()
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	reg := partition.WithEndpointRegistry(partition.NewStaticRegistry(addrs))
+
+	routers := []partition.Router{}
+	for _, addr := range addrs {
+		r, err := partition.New(ctx, addr, owner(addr), reg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		routers = append(routers, r)
+	}
+
+	counting := &countingRegistry{EndpointRegistry: partition.NewStaticRegistry(addrs)}
+	client, err := partition.New(ctx, "client", nil, partition.WithEndpointRegistry(counting))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	items := []partition.Item{}
+	for kk := 0; kk < 50; kk++ {
+		items = append(items, partition.Item{Hash: uint64(kk), Input: []byte(fmt.Sprint(kk))})
+	}
+
+	for _, r := range []partition.Router{routers[0], client} {
+		results, err := r.RunBatch(ctx, items)
+		if err != nil || len(results) != len(items) {
+			t.Fatal("unexpected", results, err)
+		}
+
+		seen := map[string]bool{}
+		for kk, result := range results {
+			expected, err := routers[1].Run(ctx, items[kk].Hash, items[kk].Input)
+			if err != nil || result.Err != nil || string(result.Response) != string(expected) {
+				t.Fatal("unexpected", kk, string(result.Response), result.Err, string(expected), err)
+			}
+			seen[string(result.Response[:len(addrs[0])])] = true
+		}
+		if len(seen) != len(addrs) {
+			t.Fatal("batch did not span all endpoints", seen)
+		}
+	}
+
+	if n := atomic.LoadInt32(&counting.calls); n != 1 {
+		t.Fatal("endpoints listed more than once for the batch", n)
+	}
+}
+
+// freeAddr returns a local address with a port which is not in use
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// countingRegistry counts the calls to ListEndpoints
+type countingRegistry struct {
+	partition.EndpointRegistry
+	calls int32
+}
+
+func (c *countingRegistry) ListEndpoints(ctx context.Context, refresh bool) ([]string, error) {
+	atomic.AddInt32(&c.calls, 1)
+	return c.EndpointRegistry.ListEndpoints(ctx, refresh)
+}
+
+type owner string
+
+func (o owner) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+	return []byte(string(o) + " " + string(input)), nil
+}
@@ -0,0 +1,123 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"math"
+	"sync"
+)
+
+// NewBoundedLoadPicker returns a picker which uses consistent hashing
+// with bounded loads.
+//
+// Hashes are mapped to the hash ring used by NewHashRing but an
+// endpoint is skipped if it already has more than c times the
+// average number of in-flight requests. The in-flight requests are
+// those issued by the local router, so the factor c should be
+// greater than 1.
+//
+// See https://arxiv.org/abs/1608.01350
+func NewBoundedLoadPicker(c float64) func(ctx context.Context, list []string, hash uint64) string {
+	if c < 1 {
+		c = 1
+	}
+	b := &boundedLoad{hashring: &hashring{factor: 1000}, c: c}
+	return b.Pick
+}
+
+type boundedLoad struct {
+	*hashring
+	c float64
+}
+
+func (b *boundedLoad) Pick(ctx context.Context, list []string, hash uint64) string {
+	if len(list) == 0 {
+		return ""
+	}
+
+	hashes, lookup := b.local(ctx).getSortedHashes(list)
+	start := ringIndex(hashes, hash)
+	primary := lookup[hashes[start]]
+
+	// The owner check cannot know the load seen by the caller. But
+	// at most n/c endpoints can be at capacity, so the caller must
+	// have picked one of the first n/c + 1 endpoints on the ring.
+	self, checking := ctx.Value(ownerCheckKey{}).(string)
+	limit := int(float64(len(list))/b.c) + 1
+
+	l, _ := ctx.Value(loadsKey{}).(*loads)
+	capacity := 0
+	if l != nil {
+		total := 1 + l.total(list)
+		capacity = int(math.Ceil(b.c * float64(total) / float64(len(list))))
+	}
+
+	seen := map[string]bool{}
+	for kk := 0; kk < len(hashes) && len(seen) < len(list); kk++ {
+		candidate := lookup[hashes[(start+kk)%len(hashes)]]
+		if seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+
+		switch {
+		case checking && candidate == self:
+			return self
+		case checking && len(seen) >= limit:
+			return primary
+		case !checking && (l == nil || l.get(candidate) < capacity):
+			return candidate
+		}
+	}
+	return primary
+}
+
+// ownerCheckKey is the context key used when the router verifies
+// that it owns a hash. The value is the address of the router.
+type ownerCheckKey struct{}
+
+// loadsKey is the context key for passing the in-flight requests
+// tracked by the router to the picker.
+type loadsKey struct{}
+
+// loads tracks the number of in-flight requests per endpoint
+type loads struct {
+	sync.Mutex
+	counts map[string]int
+}
+
+func (l *loads) start(addr string) {
+	l.Lock()
+	defer l.Unlock()
+	if l.counts == nil {
+		l.counts = map[string]int{}
+	}
+	l.counts[addr]++
+}
+
+func (l *loads) done(addr string) {
+	l.Lock()
+	defer l.Unlock()
+	if l.counts[addr]--; l.counts[addr] == 0 {
+		delete(l.counts, addr)
+	}
+}
+
+func (l *loads) get(addr string) int {
+	l.Lock()
+	defer l.Unlock()
+	return l.counts[addr]
+}
+
+func (l *loads) total(list []string) int {
+	l.Lock()
+	defer l.Unlock()
+	total := 0
+	for _, addr := range list {
+		total += l.counts[addr]
+	}
+	return total
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBoundedLoadPicker(t *testing.T) {
+	list := []string{"a", "b", "c", "d"}
+	ring := NewHashRing()
+	pick := NewBoundedLoadPicker(1.25)
+	ctx := context.Background()
+
+	// without loads, the picker matches the hash ring
+	for hash := uint64(0); hash < 1000; hash++ {
+		if x, y := ring(ctx, list, hash), pick(ctx, list, hash); x != y {
+			t.Fatal("mismatch", hash, x, y)
+		}
+	}
+
+	l := &loads{}
+	ctx = context.WithValue(ctx, loadsKey{}, l)
+	for hash := uint64(0); hash < 1000; hash++ {
+		l.start(pick(ctx, list, hash))
+		for _, addr := range list {
+			if l.get(addr) > 1+int(1.25*float64(hash+1)/4) {
+				t.Fatal("exceeded bound", hash, addr, l.get(addr))
+			}
+		}
+	}
+}
+
+func TestBoundedLoadPickerOwnerCheck(t *testing.T) {
+	list := []string{"a", "b", "c", "d"}
+	pick := NewBoundedLoadPicker(2)
+
+	l := &loads{}
+	ctx := context.WithValue(context.Background(), loadsKey{}, l)
+	primary := pick(ctx, list, 42)
+	for kk := 0; kk < 10; kk++ {
+		l.start(primary)
+	}
+	overflow := pick(ctx, list, 42)
+	if overflow == primary {
+		t.Fatal("did not overflow", primary)
+	}
+
+	check := context.WithValue(context.Background(), ownerCheckKey{}, overflow)
+	if x := pick(check, list, 42); x != overflow {
+		t.Fatal("owner check failed", x, overflow)
+	}
+
+	accepted := 0
+	for _, addr := range list {
+		check := context.WithValue(context.Background(), ownerCheckKey{}, addr)
+		if pick(check, list, 42) == addr {
+			accepted++
+		}
+	}
+	if accepted != 3 {
+		t.Fatal("unexpected number of accepted owners", accepted)
+	}
+}
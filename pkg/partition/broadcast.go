@@ -0,0 +1,80 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"sync"
+)
+
+// defaultParallelism is the default number of concurrent requests
+// made by Broadcast.
+const defaultParallelism = 16
+
+// Result is the outcome of a request to a single endpoint.
+type Result struct {
+	Response []byte
+	Err      error
+}
+
+// IsBroadcast returns true if the request was issued via
+// Router.Broadcast.
+//
+// Broadcast requests are not checked for ownership and have a hash
+// of zero.
+func IsBroadcast(ctx context.Context) bool {
+	broadcast, _ := ctx.Value(broadcastKey{}).(bool)
+	return broadcast
+}
+
+// broadcastKey is the context key used to expose the broadcast flag
+// of the header to handlers.
+type broadcastKey struct{}
+
+// Broadcast implements Router.Broadcast.
+func (s *state) Broadcast(ctx context.Context, input []byte) (map[string]Result, error) {
+	list, err := s.listEndpoints(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = header{broadcast: true}.attach(ctx)
+	parallelism := s.parallelism
+	if parallelism <= 0 {
+		parallelism = defaultParallelism
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	results := make(map[string]Result, len(list))
+	slots := make(chan struct{}, parallelism)
+	for _, addr := range list {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			output, err := s.runOn(ctx, addr, 0, input)
+			mu.Lock()
+			defer mu.Unlock()
+			results[addr] = Result{output, err}
+		}(addr)
+	}
+	wg.Wait()
+
+	errs, succeeded := errors{}, 0
+	for _, addr := range list {
+		if err := results[addr].Err; err != nil {
+			errs.check(err)
+		} else {
+			succeeded++
+		}
+	}
+	if s.partialResults && succeeded > 0 {
+		return results, nil
+	}
+	return results, errs.toError()
+}
@@ -0,0 +1,111 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tvastar/cluster/pkg/partition"
+	"github.com/tvastar/cluster/pkg/partition/partitiontest"
+)
+
+func TestBroadcast(t *testing.T) {
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	opts := []partition.Option{partition.WithEndpointRegistry(reg), partition.WithNetwork(nw)}
+
+	for _, addr := range []string{"one", "two", "three"} {
+		r, err := partition.New(ctx, addr, echo(addr), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+	}
+
+	r, err := partition.New(ctx, "client", nil, append(opts, partition.WithParallelism(2))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	results, err := r.Broadcast(ctx, []byte("hello"))
+	if err != nil || len(results) != 3 {
+		t.Fatal("unexpected", results, err)
+	}
+	for addr, res := range results {
+		if res.Err != nil || string(res.Response) != addr+" hello" {
+			t.Error("unexpected", addr, string(res.Response), res.Err)
+		}
+	}
+
+	nw.Isolate("two")
+	results, err = r.Broadcast(ctx, []byte("hello"))
+	if err != partitiontest.ErrUnreachable || results["two"].Err != partitiontest.ErrUnreachable {
+		t.Fatal("unexpected", results, err)
+	}
+
+	r, err = partition.New(ctx, "partial", nil, append(opts, partition.WithPartialResults(true))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	results, err = r.Broadcast(ctx, []byte("hello"))
+	if err != nil || results["two"].Err != partitiontest.ErrUnreachable || results["one"].Err != nil {
+		t.Fatal("unexpected", results, err)
+	}
+}
+
+type echo string
+
+func (e echo) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+	if !partition.IsBroadcast(ctx) {
+		return nil, context.Canceled
+	}
+	return []byte(string(e) + " " + string(input)), nil
+}
+
+func TestBroadcastNestedRequests(t *testing.T) {
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	opts := []partition.Option{partition.WithEndpointRegistry(reg), partition.WithNetwork(nw)}
+
+	var last partition.Router
+	for _, addr := range []string{"one", "two"} {
+		h := &relay{}
+		r, err := partition.New(ctx, addr, h, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		h.Router, last = r, r
+	}
+
+	results, err := last.Broadcast(ctx, []byte("hello"))
+	if err != nil || len(results) == 0 {
+		t.Fatal("unexpected", results, err)
+	}
+	for addr, res := range results {
+		if res.Err != nil || string(res.Response) != "direct" {
+			t.Error("unexpected", addr, string(res.Response), res.Err)
+		}
+	}
+}
+
+// relay makes a nested request with the context of a broadcast
+type relay struct {
+	partition.Router
+}
+
+func (r *relay) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+	switch {
+	case partition.IsBroadcast(ctx) && string(input) == "nested":
+		return []byte("leaked"), nil
+	case partition.IsBroadcast(ctx):
+		return r.Router.Run(ctx, 42, []byte("nested"))
+	}
+	return []byte("direct"), nil
+}
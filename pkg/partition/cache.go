@@ -0,0 +1,197 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// NewCachingRegistry returns a registry which caches the endpoints
+// listed by the provided registry for the ttl duration.
+//
+// Calls to ListEndpoints with refresh set always reload the list
+// from the provided registry. Concurrent reloads are coalesced into
+// a single call which is not affected by callers giving up.
+//
+// The returned registry also implements MetadataRegistry, caching
+// the metadata if the provided registry supports it.
+//
+// If the provided registry implements EndpointWatcher, so does the
+// returned registry and the cache is invalidated with every change
+// reported by the watch.
+//
+// If the provided registry implements VersionedRegistry, so does the
+// returned registry and the version is cached along with the list.
+func NewCachingRegistry(inner EndpointRegistry, ttl time.Duration) EndpointRegistry {
+	c := &cachingreg{EndpointRegistry: inner, ttl: ttl}
+	_, versioned := inner.(VersionedRegistry)
+	if w, ok := inner.(EndpointWatcher); ok {
+		if versioned {
+			return versionedwatcher{&cachingwatcher{c, w}}
+		}
+		return &cachingwatcher{c, w}
+	}
+	if versioned {
+		return versionedcache{c}
+	}
+	return c
+}
+
+type cachingreg struct {
+	EndpointRegistry
+	ttl time.Duration
+
+	sync.Mutex
+	cached  *load
+	expires time.Time
+	loading *load
+}
+
+// load tracks a single reload
+type load struct {
+	done      chan struct{}
+	list      []string
+	endpoints []Endpoint
+	version   uint64 // only set for a VersionedRegistry
+	err       error
+}
+
+func (c *cachingreg) RegisterEndpoint(ctx context.Context, addr string) (io.Closer, error) {
+	closer, err := c.EndpointRegistry.RegisterEndpoint(ctx, addr)
+	c.invalidate()
+	return closer, err
+}
+
+func (c *cachingreg) ListEndpoints(ctx context.Context, refresh bool) ([]string, error) {
+	l := c.get(ctx, refresh)
+	return l.list, l.err
+}
+
+func (c *cachingreg) RegisterEndpointMetadata(ctx context.Context, ep Endpoint) (io.Closer, error) {
+	closer, err := registerEndpointMetadata(ctx, c.EndpointRegistry, ep)
+	c.invalidate()
+	return closer, err
+}
+
+func (c *cachingreg) ListEndpointMetadata(ctx context.Context, refresh bool) ([]Endpoint, error) {
+	l := c.get(ctx, refresh)
+	return l.endpoints, l.err
+}
+
+func (c *cachingreg) listVersioned(ctx context.Context, refresh bool) ([]string, uint64, error) {
+	l := c.get(ctx, refresh)
+	return l.list, l.version, l.err
+}
+
+func (c *cachingreg) get(ctx context.Context, refresh bool) *load {
+	c.Lock()
+	if !refresh && c.cached != nil && time.Now().Before(c.expires) {
+		defer c.Unlock()
+		return c.cached
+	}
+
+	l := c.loading
+	if l == nil {
+		l = &load{done: make(chan struct{})}
+		c.loading = l
+		go c.reload(l)
+	}
+	c.Unlock()
+
+	select {
+	case <-l.done:
+		return l
+	case <-ctx.Done():
+		return &load{err: ctx.Err()}
+	}
+}
+
+// reload fetches the list from the provided registry. This uses its
+// own context so that callers which give up do not fail the others
+// waiting on the same reload.
+func (c *cachingreg) reload(l *load) {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheLoadTimeout)
+	defer cancel()
+
+	if v, ok := c.EndpointRegistry.(VersionedRegistry); ok {
+		l.list, l.version, l.err = v.ListVersionedEndpoints(ctx, true)
+		l.endpoints = endpointsOf(l.list)
+		if _, ok := c.EndpointRegistry.(MetadataRegistry); ok && l.err == nil {
+			l.endpoints, l.err = listEndpointMetadata(ctx, c.EndpointRegistry, true)
+		}
+	} else {
+		l.endpoints, l.err = listEndpointMetadata(ctx, c.EndpointRegistry, true)
+		l.list = addrsOf(l.endpoints)
+	}
+
+	c.Lock()
+	c.loading = nil
+	if l.err == nil {
+		c.cached, c.expires = l, time.Now().Add(c.ttl)
+	}
+	c.Unlock()
+	close(l.done)
+}
+
+// cacheLoadTimeout bounds a single reload of the cached list.
+const cacheLoadTimeout = 10 * time.Second
+
+func (c *cachingreg) externalMembership() bool {
+	return isExternalMembership(c.EndpointRegistry)
+}
+
+func (c *cachingreg) invalidate() {
+	c.Lock()
+	defer c.Unlock()
+	c.expires = time.Time{}
+}
+
+type cachingwatcher struct {
+	*cachingreg
+	inner EndpointWatcher
+}
+
+func (c *cachingwatcher) WatchEndpoints(ctx context.Context) (<-chan []string, error) {
+	ch, err := c.inner.WatchEndpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(chan []string, 1)
+	go func() {
+		defer close(result)
+		for list := range ch {
+			c.invalidate()
+			select {
+			case result <- list:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return result, nil
+}
+
+// versionedcache is a cachingreg for a VersionedRegistry
+type versionedcache struct {
+	*cachingreg
+}
+
+// ListVersionedEndpoints implements VersionedRegistry.
+func (v versionedcache) ListVersionedEndpoints(ctx context.Context, refresh bool) ([]string, uint64, error) {
+	return v.listVersioned(ctx, refresh)
+}
+
+// versionedwatcher is a cachingwatcher for a VersionedRegistry
+type versionedwatcher struct {
+	*cachingwatcher
+}
+
+// ListVersionedEndpoints implements VersionedRegistry.
+func (v versionedwatcher) ListVersionedEndpoints(ctx context.Context, refresh bool) ([]string, uint64, error) {
+	return v.listVersioned(ctx, refresh)
+}
@@ -0,0 +1,190 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCachingRegistry(t *testing.T) {
+	inner := &countingRegistry{}
+	c := NewCachingRegistry(inner, time.Hour)
+	ctx := context.Background()
+
+	for kk := 0; kk < 5; kk++ {
+		if list, err := c.ListEndpoints(ctx, false); err != nil || len(list) != 1 {
+			t.Fatal("unexpected", list, err)
+		}
+	}
+	if n := atomic.LoadInt32(&inner.calls); n != 1 {
+		t.Fatal("unexpected number of calls", n)
+	}
+
+	if _, err := c.ListEndpoints(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&inner.calls); n != 2 {
+		t.Fatal("refresh did not reload", n)
+	}
+
+	if _, err := c.RegisterEndpoint(ctx, "boo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListEndpoints(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&inner.calls); n != 3 {
+		t.Fatal("register did not invalidate", n)
+	}
+}
+
+func TestCachingRegistryCoalesces(t *testing.T) {
+	inner := &countingRegistry{block: make(chan struct{})}
+	c := NewCachingRegistry(inner, time.Hour)
+	ctx := context.Background()
+
+	var wg, waiting sync.WaitGroup
+	for kk := 0; kk < 10; kk++ {
+		wg.Add(1)
+		waiting.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := &waitingContext{Context: ctx, waiting: &waiting}
+			if list, err := c.ListEndpoints(ctx, true); err != nil || len(list) != 1 {
+				t.Error("unexpected", list, err)
+			}
+		}()
+	}
+
+	// unblock the load once every caller waits on it
+	waiting.Wait()
+	close(inner.block)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&inner.calls); n != 1 {
+		t.Fatal("reloads not coalesced", n)
+	}
+}
+
+func TestCachingRegistryDetachesLoad(t *testing.T) {
+	inner := &countingRegistry{block: make(chan struct{})}
+	c := NewCachingRegistry(inner, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := c.ListEndpoints(ctx, true)
+		first <- err
+	}()
+	for atomic.LoadInt32(&inner.calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Fatal("unexpected", err)
+	}
+
+	// the second caller either joins the pending load or uses
+	// its result
+	second := make(chan error, 1)
+	go func() {
+		_, err := c.ListEndpoints(context.Background(), false)
+		second <- err
+	}()
+	close(inner.block)
+	if err := <-second; err != nil {
+		t.Fatal("cancelled caller failed the shared load", err)
+	}
+	if n := atomic.LoadInt32(&inner.calls); n != 1 {
+		t.Fatal("unexpected number of calls", n)
+	}
+}
+
+func TestCachingRegistryVersioned(t *testing.T) {
+	if _, ok := NewCachingRegistry(&countingRegistry{}, time.Hour).(VersionedRegistry); ok {
+		t.Fatal("unversioned registry reported as versioned")
+	}
+	if _, ok := NewCachingRegistry(versionedWatcher{&countingVersioned{}}, time.Hour).(VersionedRegistry); !ok {
+		t.Fatal("versioned watcher not reported as versioned")
+	}
+
+	inner := &countingVersioned{}
+	c, ok := NewCachingRegistry(inner, time.Hour).(VersionedRegistry)
+	if !ok {
+		t.Fatal("versioned registry not reported as versioned")
+	}
+	ctx := context.Background()
+
+	for kk := 0; kk < 5; kk++ {
+		if list, version, err := c.ListVersionedEndpoints(ctx, false); err != nil || len(list) != 1 || version != 1 {
+			t.Fatal("unexpected", list, version, err)
+		}
+	}
+	if _, err := c.(EndpointRegistry).ListEndpoints(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&inner.calls); n != 1 {
+		t.Fatal("unexpected number of calls", n)
+	}
+
+	if _, version, err := c.ListVersionedEndpoints(ctx, true); err != nil || version != 2 {
+		t.Fatal("refresh did not reload", version, err)
+	}
+}
+
+// waitingContext reports when a caller starts waiting for it to be
+// done
+type waitingContext struct {
+	context.Context
+	waiting *sync.WaitGroup
+	once    sync.Once
+}
+
+func (w *waitingContext) Done() <-chan struct{} {
+	w.once.Do(w.waiting.Done)
+	return w.Context.Done()
+}
+
+type countingRegistry struct {
+	calls int32
+	block chan struct{}
+}
+
+func (c *countingRegistry) RegisterEndpoint(ctx context.Context, addr string) (io.Closer, error) {
+	return stubRunner(nil), nil
+}
+
+func (c *countingRegistry) ListEndpoints(ctx context.Context, refresh bool) ([]string, error) {
+	atomic.AddInt32(&c.calls, 1)
+	if c.block != nil {
+		<-c.block
+	}
+	return []string{"boo"}, nil
+}
+
+// countingVersioned increments the version with every list
+type countingVersioned struct {
+	countingRegistry
+}
+
+func (v *countingVersioned) ListVersionedEndpoints(ctx context.Context, refresh bool) ([]string, uint64, error) {
+	list, err := v.ListEndpoints(ctx, refresh)
+	return list, uint64(atomic.LoadInt32(&v.calls)), err
+}
+
+type versionedWatcher struct {
+	*countingVersioned
+}
+
+func (v versionedWatcher) WatchEndpoints(ctx context.Context) (<-chan []string, error) {
+	return nil, fmt.Errorf("not implemented")
+}
@@ -0,0 +1,178 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// ConnState is the state of the connection to an endpoint.
+type ConnState int
+
+const (
+	// ConnReady indicates that the endpoint was dialed
+	// successfully.
+	ConnReady ConnState = iota
+
+	// ConnFailed indicates that dialing the endpoint failed. It
+	// is redialed with exponential backoff.
+	ConnFailed
+)
+
+// String returns the name of the state
+func (c ConnState) String() string {
+	if c == ConnFailed {
+		return "failed"
+	}
+	return "ready"
+}
+
+const (
+	minDialBackoff = 100 * time.Millisecond
+	maxDialBackoff = 10 * time.Second
+)
+
+// conn tracks the client for an endpoint.
+//
+// Clients are reaped once their endpoint leaves the cluster but are
+// only closed after all in-flight requests using them are done.
+type conn struct {
+	client   RunCloser
+	err      error
+	failures int
+	retryAt  time.Time
+	refs     int
+	reaped   bool
+}
+
+func (c *conn) state() ConnState {
+	if c.client == nil {
+		return ConnFailed
+	}
+	return ConnReady
+}
+
+// client returns the client for the endpoint, dialing it if
+// needed. The release function must be called once the client is
+// no longer in use.
+func (s *state) client(ctx context.Context, addr string) (RunCloser, func(), error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.clients == nil {
+		return nil, nil, errClosed
+	}
+
+	c := s.clients[addr]
+	if c == nil {
+		c = &conn{}
+		s.clients[addr] = c
+	}
+
+	if c.client == nil {
+		if c.failures > 0 && time.Now().Before(c.retryAt) {
+			return nil, nil, c.err
+		}
+
+		if c.client, c.err = s.DialClient(ctx, addr); c.err != nil {
+			c.client = nil
+			c.failures++
+			c.retryAt = time.Now().Add(dialBackoff(c.failures))
+			return nil, nil, c.err
+		}
+		c.failures = 0
+	}
+
+	c.refs++
+	return c.client, func() { s.release(c) }, nil
+}
+
+func (s *state) release(c *conn) {
+	s.Lock()
+	defer s.Unlock()
+
+	if c.refs--; c.refs == 0 && c.reaped && c.client != nil {
+		c.client.Close()
+	}
+}
+
+// observe is called with every list of endpoints seen by the
+// router along with the metadata, if available. Clients of
+// endpoints which are no longer in the list are reaped.
+//
+// Lists which only differ in order are not considered a change as
+// registries need not list endpoints in a stable order.
+func (s *state) observe(list []string, eps []Endpoint) {
+	s.Lock()
+	defer s.Unlock()
+
+	if sameMembers(s.observed, list) {
+		return
+	}
+	s.changed(s.observed, list, s.observedEps, eps)
+	s.observed, s.observedEps = list, eps
+
+	members := make(map[string]bool, len(list))
+	for _, addr := range list {
+		members[addr] = true
+	}
+
+	for addr, c := range s.clients {
+		if members[addr] {
+			continue
+		}
+		delete(s.clients, addr)
+		c.reaped = true
+		if c.refs == 0 && c.client != nil {
+			c.client.Close()
+		}
+	}
+}
+
+// sameMembers checks if both lists have the same endpoints,
+// ignoring the order.
+func sameMembers(l1, l2 []string) bool {
+	if len(l1) != len(l2) {
+		return false
+	}
+	members := make(map[string]bool, len(l1))
+	for _, addr := range l1 {
+		members[addr] = true
+	}
+	for _, addr := range l2 {
+		if !members[addr] {
+			return false
+		}
+	}
+	return true
+}
+
+// Connections implements Router.Connections.
+func (s *state) Connections() map[string]ConnState {
+	s.Lock()
+	defer s.Unlock()
+
+	result := make(map[string]ConnState, len(s.clients))
+	for addr, c := range s.clients {
+		result[addr] = c.state()
+	}
+	return result
+}
+
+// dialBackoff returns the delay before redialing an endpoint after
+// the specified number of consecutive failures.
+func dialBackoff(failures int) time.Duration {
+	delay := minDialBackoff
+	for kk := 1; kk < failures && delay < maxDialBackoff; kk++ {
+		delay *= 2
+	}
+	if delay > maxDialBackoff {
+		delay = maxDialBackoff
+	}
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
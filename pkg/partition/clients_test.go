@@ -0,0 +1,141 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientsLifecycle(t *testing.T) {
+	reg := stubRegistry{false: {"a", "b", "c"}}
+	nw := &trackingNetwork{closed: map[string]bool{}}
+	ctx := context.Background()
+
+	r, err := New(ctx, "", nil, WithEndpointRegistry(reg), WithNetwork(nw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	results, err := r.Broadcast(ctx, nil)
+	if err == nil || results["c"].Err == nil || results["a"].Err != nil {
+		t.Fatal("unexpected", results, err)
+	}
+
+	expected := map[string]ConnState{"a": ConnReady, "b": ConnReady, "c": ConnFailed}
+	if x := r.Connections(); !reflect.DeepEqual(x, expected) {
+		t.Fatal("unexpected", x)
+	}
+
+	// failed dials are not retried right away
+	if _, err := r.Broadcast(ctx, nil); err == nil || nw.dials("c") != 1 {
+		t.Fatal("unexpected", err, nw.dials("c"))
+	}
+
+	reg[false] = []string{"a"}
+	if _, err := r.Broadcast(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = map[string]ConnState{"a": ConnReady}
+	if x := r.Connections(); !reflect.DeepEqual(x, expected) {
+		t.Fatal("unexpected", x)
+	}
+	if !nw.isClosed("b") || nw.isClosed("a") {
+		t.Fatal("departed endpoints not closed", nw.closed)
+	}
+}
+
+// trackingNetwork fails to dial "c" and tracks closed clients
+type trackingNetwork struct {
+	sync.Mutex
+	closed map[string]bool
+	counts map[string]int
+}
+
+func (n *trackingNetwork) DialClient(ctx context.Context, addr string) (RunCloser, error) {
+	n.Lock()
+	defer n.Unlock()
+	if n.counts == nil {
+		n.counts = map[string]int{}
+	}
+	if n.counts[addr]++; addr == "c" {
+		return nil, fmt.Errorf("dial failed")
+	}
+	return trackingClient{n, addr}, nil
+}
+
+func (n *trackingNetwork) RegisterServer(ctx context.Context, addr string, handler Runner) (io.Closer, error) {
+	return stubRunner(nil), nil
+}
+
+func (n *trackingNetwork) dials(addr string) int {
+	n.Lock()
+	defer n.Unlock()
+	return n.counts[addr]
+}
+
+func (n *trackingNetwork) isClosed(addr string) bool {
+	n.Lock()
+	defer n.Unlock()
+	return n.closed[addr]
+}
+
+type trackingClient struct {
+	*trackingNetwork
+	addr string
+}
+
+func (c trackingClient) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+	return nil, nil
+}
+
+func (c trackingClient) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	c.closed[c.addr] = true
+	return nil
+}
+
+func TestObserveReordered(t *testing.T) {
+	changes := make(chan OwnershipChange, 10)
+	notify := WithOwnershipChange(func(c OwnershipChange) { changes <- c })
+	handler := stubRunner(func() ([]byte, error) { return nil, nil })
+	reg := stubRegistry{false: {"a"}, true: {"a"}}
+	ctx := context.Background()
+
+	r, err := New(ctx, "a", handler, WithEndpointRegistry(reg), WithNetwork(stubNetwork{}), notify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	s := r.(*state)
+	s.observe([]string{"a", "b"}, nil)
+	s.observe([]string{"b", "a"}, nil)
+	s.observe([]string{"c", "b", "a"}, nil)
+
+	// changes are delivered in order so a reorder would show up
+	// before the final change
+	for {
+		select {
+		case c := <-changes:
+			if len(c.Old) == len(c.New) {
+				t.Fatal("unexpected change", c.Old, c.New)
+			}
+			if len(c.New) == 3 {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for ownership change")
+		}
+	}
+}
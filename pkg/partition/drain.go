@@ -0,0 +1,107 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"time"
+)
+
+// drainPollInterval is how often Drain checks if it is done.
+const drainPollInterval = 10 * time.Millisecond
+
+// defaultDrainTimeout bounds Drain if the context has no deadline.
+const defaultDrainTimeout = time.Minute
+
+// Drain implements Router.Drain.
+func (s *state) Drain(ctx context.Context) error {
+	errs := errors{}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, defaultDrainTimeout)
+		defer cancel()
+	}
+
+	s.Lock()
+	s.draining = true
+	epCloser := s.epCloser
+	s.epCloser = nil
+	peers := s.observed
+	s.Unlock()
+
+	if epCloser != nil {
+		errs.check(epCloser.Close())
+	}
+
+	// registries such as NewStaticRegistry keep listing the
+	// local endpoint, so there is no point waiting for that
+	listed := isExternalMembership(s.EndpointRegistry)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for done := false; !done && !s.drained(ctx, listed, peers); {
+		select {
+		case <-ctx.Done():
+			errs.check(ctx.Err())
+			done = true
+		case <-ticker.C:
+		}
+	}
+
+	s.Lock()
+	serverCloser := s.serverCloser
+	s.serverCloser = nil
+	s.Unlock()
+
+	if serverCloser != nil {
+		errs.check(serverCloser.Close())
+	}
+	return errs.toError()
+}
+
+// drained checks if there are no in-flight requests, the registry
+// no longer lists the local endpoint and the peers have pulled the
+// state of the local endpoint (see Migrator). The latter two are
+// skipped if the registry cannot remove the endpoint.
+func (s *state) drained(ctx context.Context, listed bool, peers []string) bool {
+	s.Lock()
+	inflight := s.inflight
+	s.Unlock()
+
+	if inflight > 0 {
+		return false
+	}
+	if listed {
+		return true
+	}
+
+	list, err := s.ListEndpoints(ctx, true)
+	if err != nil {
+		return false
+	}
+	for _, addr := range list {
+		if addr == s.addr {
+			return false
+		}
+	}
+	return s.migrated(list, peers)
+}
+
+// enter tracks a request to the local handler. It returns false if
+// the router is draining.
+func (s safe) enter() bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.draining {
+		return false
+	}
+	s.inflight++
+	return true
+}
+
+func (s safe) exit() {
+	s.Lock()
+	defer s.Unlock()
+	s.inflight--
+}
@@ -0,0 +1,204 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/tvastar/cluster/pkg/partition"
+	"github.com/tvastar/cluster/pkg/partition/partitiontest"
+)
+
+func TestDrain(t *testing.T) {
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	opts := []partition.Option{partition.WithEndpointRegistry(reg), partition.WithNetwork(nw)}
+
+	started, finish := make(chan bool, 1), make(chan bool)
+	one, err := partition.New(ctx, "one", slow{started, finish}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer one.Close()
+
+	two, err := partition.New(ctx, "two", owner("two"), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer two.Close()
+
+	// find a hash owned by "one"
+	hash := uint64(0)
+	for ; partition.NewPicker()(ctx, []string{"one", "two"}, hash) != "one"; hash++ {
+	}
+
+	inflight := make(chan error, 1)
+	go func() {
+		_, err := two.Run(ctx, hash, nil)
+		inflight <- err
+	}()
+	<-started
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- one.Drain(ctx)
+	}()
+
+	// wait for the endpoint to be deregistered
+	for list, _ := reg.ListEndpoints(ctx, false); len(list) != 1; list, _ = reg.ListEndpoints(ctx, false) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// new requests go to the other endpoint while draining
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		res, err := two.Run(ctx, hash, []byte("x"))
+		if err == nil && string(res) == "two x" {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("request not rerouted", string(res), err)
+		}
+	}
+
+	select {
+	case err := <-drained:
+		t.Fatal("drain did not wait for in-flight requests", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(finish)
+	if err := <-inflight; err != nil {
+		t.Fatal("in-flight request failed", err)
+	}
+	if err := <-drained; err != nil {
+		t.Fatal("drain failed", err)
+	}
+}
+
+type slow struct {
+	started, finish chan bool
+}
+
+func (s slow) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+	s.started <- true
+	<-s.finish
+	return input, nil
+}
+
+func TestDrainMigrates(t *testing.T) {
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	opts := []partition.Option{partition.WithEndpointRegistry(reg), partition.WithNetwork(nw)}
+
+	changes := make(chan partition.OwnershipChange, 10)
+	notify := partition.WithOwnershipChange(func(c partition.OwnershipChange) { changes <- c })
+	one, err := partition.New(ctx, "one", &kvStore{data: map[uint64][]byte{}}, append(opts, notify)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer one.Close()
+	next(t, changes)
+
+	two, err := partition.New(ctx, "two", &kvStore{data: map[uint64][]byte{}}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer two.Close()
+	next(t, changes)
+
+	hash := uint64(0)
+	for ; pick([]string{"one", "two"}, hash) != "one"; hash++ {
+	}
+	if _, err := one.Run(ctx, hash, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the state is pulled by two before one stops serving
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := one.Drain(ctx); err != nil {
+		t.Fatal("drain failed", err)
+	}
+	if res, err := two.Run(ctx, hash, nil); err != nil || string(res) != "value" {
+		t.Fatal("state lost", string(res), err)
+	}
+}
+
+func TestDrainStaticRegistry(t *testing.T) {
+	ctx := context.Background()
+	reg := partition.WithEndpointRegistry(partition.NewStaticRegistry([]string{"one"}))
+	one, err := partition.New(ctx, "one", owner("one"), reg, partition.WithNetwork(partitiontest.NewNetwork()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer one.Close()
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- one.Drain(ctx)
+	}()
+
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("drain waited on a static registry")
+	}
+}
+
+func TestDrainRedisRegistry(t *testing.T) {
+	minir, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer minir.Close()
+
+	ctx := context.Background()
+	reg := partition.NewRedisRegistry(minir.Addr(), "prefix_")
+	defer reg.(io.Closer).Close()
+
+	opts := []partition.Option{partition.WithEndpointRegistry(reg), partition.WithNetwork(partitiontest.NewNetwork())}
+	one, err := partition.New(ctx, "one", owner("one"), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer one.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := one.Drain(ctx); err != nil || time.Since(start) > time.Second {
+		t.Fatal("unexpected", time.Since(start), err)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	opts := []partition.Option{partition.WithEndpointRegistry(reg), partition.WithNetwork(nw)}
+
+	started, finish := make(chan bool, 1), make(chan bool)
+	defer close(finish)
+	one, err := partition.New(ctx, "one", slow{started, finish}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer one.Close()
+
+	go one.Run(ctx, 0, nil)
+	<-started
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := one.Drain(ctx); err != context.DeadlineExceeded {
+		t.Fatal("unexpected", err)
+	}
+}
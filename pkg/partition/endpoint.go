@@ -0,0 +1,99 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Endpoint describes a single server in the cluster along with its
+// metadata.
+type Endpoint struct {
+	Addr string `json:"addr"`
+
+	// Zone is the locality (such as the availability zone) of
+	// the endpoint.
+	Zone string `json:"zone,omitempty"`
+
+	// Version is the version of the software running on the
+	// endpoint.
+	Version string `json:"version,omitempty"`
+
+	// Weight is the relative capacity of the endpoint. Zero is
+	// treated as a weight of 1.
+	Weight float64 `json:"weight,omitempty"`
+
+	// Labels holds arbitrary application-specific metadata.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+func (e Endpoint) weight() float64 {
+	if e.Weight <= 0 {
+		return 1
+	}
+	return e.Weight
+}
+
+func (e Endpoint) hasMetadata() bool {
+	return e.Zone != "" || e.Version != "" || e.Weight != 0 || len(e.Labels) > 0
+}
+
+// MetadataRegistry is optionally implemented by an EndpointRegistry
+// which can store metadata along with the address of each endpoint.
+//
+// The router registers its endpoint via RegisterEndpointMetadata
+// when the registry supports it and uses ListEndpointMetadata when
+// configured with WithEndpointPicker.
+type MetadataRegistry interface {
+	RegisterEndpointMetadata(ctx context.Context, ep Endpoint) (io.Closer, error)
+	ListEndpointMetadata(ctx context.Context, refresh bool) ([]Endpoint, error)
+}
+
+var errNoMetadata = fmt.Errorf("partition: registry does not support endpoint metadata")
+
+// registerEndpointMetadata registers the endpoint with metadata if
+// the registry supports it.  Registries which do not support
+// metadata are only allowed for endpoints without metadata.
+func registerEndpointMetadata(ctx context.Context, r EndpointRegistry, ep Endpoint) (io.Closer, error) {
+	if m, ok := r.(MetadataRegistry); ok {
+		return m.RegisterEndpointMetadata(ctx, ep)
+	}
+	if ep.hasMetadata() {
+		return nil, errNoMetadata
+	}
+	return r.RegisterEndpoint(ctx, ep.Addr)
+}
+
+// listEndpointMetadata lists the endpoints with metadata if the
+// registry supports it and without metadata otherwise.
+func listEndpointMetadata(ctx context.Context, r EndpointRegistry, refresh bool) ([]Endpoint, error) {
+	if m, ok := r.(MetadataRegistry); ok {
+		return m.ListEndpointMetadata(ctx, refresh)
+	}
+
+	list, err := r.ListEndpoints(ctx, refresh)
+	if err != nil {
+		return nil, err
+	}
+	return endpointsOf(list), nil
+}
+
+func endpointsOf(list []string) []Endpoint {
+	result := make([]Endpoint, len(list))
+	for kk, addr := range list {
+		result[kk] = Endpoint{Addr: addr}
+	}
+	return result
+}
+
+func addrsOf(eps []Endpoint) []string {
+	result := make([]string, len(eps))
+	for kk, ep := range eps {
+		result[kk] = ep.Addr
+	}
+	return result
+}
@@ -0,0 +1,104 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import "context"
+
+// VersionedRegistry is optionally implemented by an
+// EndpointRegistry which tracks a version (or epoch) of the
+// membership which increases on every change.
+//
+// The router sends its version with every request. Endpoints
+// refresh their own list when the caller has a newer version and
+// reject requests from callers with an older version with an
+// IncorrectPartitionError which includes their list of endpoints.
+// The caller uses that list until the registry catches up.
+type VersionedRegistry interface {
+	ListVersionedEndpoints(ctx context.Context, refresh bool) ([]string, uint64, error)
+}
+
+// withEpoch adds the version of the list of endpoints in use to the
+// header of an outgoing request.
+func (s *state) withEpoch(ctx context.Context) context.Context {
+	h := headerOf(ctx)
+	h.epoch = s.currentVersion()
+	return h.attach(ctx)
+}
+
+// listVersioned lists the endpoints from the registry, tracking the
+// version if the registry supports it.
+func (s *state) listVersioned(ctx context.Context, refresh bool) ([]string, error) {
+	v, ok := s.EndpointRegistry.(VersionedRegistry)
+	if !ok {
+		return s.ListEndpoints(ctx, refresh)
+	}
+
+	list, version, err := v.ListVersionedEndpoints(ctx, refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	return s.newest(list, version), nil
+}
+
+// newest records the version of the list and returns the hinted
+// list instead if that is newer. This must be called with the lock
+// held.
+func (s *state) newest(list []string, version uint64) []string {
+	if version > s.version {
+		s.version = version
+	}
+	if s.hintVersion > version {
+		return s.hint
+	}
+	return list
+}
+
+// currentVersion returns the version of the list of endpoints in
+// use.
+func (s *state) currentVersion() uint64 {
+	s.Lock()
+	defer s.Unlock()
+	if s.hintVersion > s.version {
+		return s.hintVersion
+	}
+	return s.version
+}
+
+// adopt uses the list of endpoints provided by another endpoint if
+// it is newer than the local one.
+func (s *state) adopt(err error) {
+	e, ok := err.(IncorrectPartitionError)
+	if !ok || e.Endpoints == nil || len(*e.Endpoints) == 0 {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if e.Version > s.version && e.Version > s.hintVersion {
+		s.hint, s.hintVersion = *e.Endpoints, e.Version
+	}
+}
+
+// incorrect returns the error for a request which is not owned by
+// the local endpoint. The error includes the owner as seen by the
+// local endpoint as well as the local list of endpoints if the
+// caller has an older version.
+func (s safe) incorrect(ctx context.Context, h header, hash uint64) error {
+	result := IncorrectPartitionError{Version: s.currentVersion()}
+	if owner, err := s.getAddr(ctx, hash, false); err == nil && owner != s.addr {
+		result.Owner = owner
+	}
+	if h.epoch >= result.Version {
+		return result
+	}
+
+	if list, err := s.listEndpoints(ctx, false); err == nil {
+		result.Endpoints = &list
+	}
+	return result
+}
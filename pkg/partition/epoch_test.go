@@ -0,0 +1,148 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/tvastar/cluster/pkg/partition"
+	"github.com/tvastar/cluster/pkg/partition/partitiontest"
+)
+
+func TestMembershipEpoch(t *testing.T) {
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	opts := []partition.Option{partition.WithEndpointRegistry(reg), partition.WithNetwork(nw)}
+
+	routers := []partition.Router{}
+	for _, addr := range []string{"one", "two"} {
+		r, err := partition.New(ctx, addr, owner(addr), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		routers = append(routers, r)
+	}
+
+	hash := uint64(0)
+	for ; pick([]string{"one", "two"}, hash) != "two"; hash++ {
+	}
+	converge(t, routers, hash, "two")
+
+	// the client never sees "two" in its registry
+	stale := partition.WithEndpointRegistry(staleRegistry{"one"})
+	client, err := partition.New(ctx, "client", nil, partition.WithNetwork(nw), stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if res, err := client.Run(ctx, hash, []byte("x")); err != nil || string(res) != "two x" {
+		t.Fatal("unexpected", string(res), err)
+	}
+}
+
+// converge waits until all the routers send requests for the hash
+// to the expected owner.
+func converge(t *testing.T, routers []partition.Router, hash uint64, expected string) {
+	t.Helper()
+	ctx := context.Background()
+	for _, r := range routers {
+		for start := time.Now(); ; time.Sleep(time.Millisecond) {
+			if res, err := r.Run(ctx, hash, nil); err == nil && string(res) == expected+" " {
+				break
+			}
+			if time.Since(start) > time.Second {
+				t.Fatal("membership did not converge")
+			}
+		}
+	}
+}
+
+// staleRegistry always returns the same list with version 1
+type staleRegistry []string
+
+func (s staleRegistry) RegisterEndpoint(ctx context.Context, addr string) (io.Closer, error) {
+	return nil, nil
+}
+
+func (s staleRegistry) ListEndpoints(ctx context.Context, refresh bool) ([]string, error) {
+	return s, nil
+}
+
+func (s staleRegistry) ListVersionedEndpoints(ctx context.Context, refresh bool) ([]string, uint64, error) {
+	return s, 1, nil
+}
+
+func TestRedirect(t *testing.T) {
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+
+	// the endpoints do not provide their list of endpoints, so
+	// clients can only follow redirects to endpoints they know
+	unversioned := struct{ partition.EndpointRegistry }{reg}
+	opts := []partition.Option{partition.WithEndpointRegistry(unversioned), partition.WithNetwork(nw)}
+
+	routers := []partition.Router{}
+	for _, addr := range []string{"one", "two"} {
+		r, err := partition.New(ctx, addr, owner(addr), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		routers = append(routers, r)
+	}
+
+	hash := uint64(0)
+	for ; pick([]string{"one", "two"}, hash) != "two"; hash++ {
+	}
+	converge(t, routers, hash, "two")
+
+	// the client always picks "one" which redirects the request
+	// to "two"
+	first := func(ctx context.Context, list []string, hash uint64) string {
+		return list[0]
+	}
+	retries := partition.WithRetryPolicy(partition.RetryPolicy{MaxAttempts: 2})
+	client, err := partition.New(ctx, "client", nil,
+		partition.WithNetwork(nw),
+		partition.WithEndpointRegistry(partition.NewStaticRegistry([]string{"one", "two"})),
+		partition.WithPicker(first),
+		retries,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if res, err := client.Run(ctx, hash, []byte("x")); err != nil || string(res) != "two x" {
+		t.Fatal("unexpected", string(res), err)
+	}
+	results, err := client.RunBatch(ctx, []partition.Item{{Hash: hash, Input: []byte("y")}})
+	if err != nil || results[0].Err != nil || string(results[0].Response) != "two y" {
+		t.Fatal("unexpected", results, err)
+	}
+
+	// owners which the client does not know are not dialed
+	client, err = partition.New(ctx, "client", nil,
+		partition.WithNetwork(nw),
+		partition.WithEndpointRegistry(partition.NewStaticRegistry([]string{"one"})),
+		retries,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.Run(ctx, hash, []byte("x")); err == nil {
+		t.Fatal("redirected to unknown endpoint")
+	}
+	if _, ok := client.Connections()["two"]; ok {
+		t.Fatal("unknown endpoint dialed")
+	}
+}
@@ -4,6 +4,8 @@
 
 package partition
 
+import "fmt"
+
 type errors []error
 
 func (e *errors) check(err error) {
@@ -19,9 +21,33 @@ func (e *errors) toError() error {
 	return (*e)[0]
 }
 
+var errClosed = fmt.Errorf("partition: router closed")
+
+var errBatchMismatch = fmt.Errorf("partition: batch returned the wrong number of results")
+
 // IncorrectPartitionError is a transient error that happens when
 // requests end up on the wrong partition.
-type IncorrectPartitionError struct{}
+//
+// The router automatically retries these based on the RetryPolicy
+// (see WithRetryPolicy).
+//
+// Owner is the address of the endpoint which the rejecting endpoint
+// believes owns the partition. The router redirects the next
+// attempt there directly instead of waiting for the registry if
+// the owner is one of the endpoints known to the router. Only the
+// first redirect is immediate; further ones back off like any other
+// retry.
+//
+// Version is the version of the membership used by the rejecting
+// endpoint when the registry is a VersionedRegistry. If the caller
+// has an older version, the error also includes the list of
+// endpoints. The list is held by a pointer so that the error
+// remains comparable.
+type IncorrectPartitionError struct {
+	Owner     string
+	Version   uint64
+	Endpoints *[]string
+}
 
 // Error returns the error string
 func (e IncorrectPartitionError) Error() string {
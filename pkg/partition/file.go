@@ -0,0 +1,147 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// NewFileRegistry returns a registry which reads the list of
+// endpoints from a file.
+//
+// Files with a .json extension must contain a JSON array of strings
+// and files with a .yaml or .yml extension must contain a YAML
+// list of strings.  All other files are expected to have one
+// endpoint per line with blank lines and lines starting with # being
+// ignored.
+//
+// The file is reloaded whenever it changes. If the file cannot be
+// parsed or is empty (such as when it is caught in the middle of a
+// write), the last successfully loaded list continues to be used.
+//
+// RegisterEndpoint is a no-op: the file is expected to include every
+// server in the cluster.
+//
+// The returned registry also implements EndpointWatcher by polling
+// the file every second.
+func NewFileRegistry(path string) EndpointRegistry {
+	return &filereg{path: path, interval: time.Second}
+}
+
+type filereg struct {
+	path     string
+	interval time.Duration
+
+	sync.Mutex
+	modTime time.Time
+	size    int64
+	list    []string
+	err     error // from the last load
+}
+
+func (f *filereg) RegisterEndpoint(ctx context.Context, addr string) (io.Closer, error) {
+	return nopcloser{}, nil
+}
+
+func (f *filereg) externalMembership() bool {
+	return true
+}
+
+func (f *filereg) ListEndpoints(ctx context.Context, refresh bool) ([]string, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err == nil && (info.ModTime() != f.modTime || info.Size() != f.size) {
+		// the stat is recorded even if the load fails so that a
+		// broken file is not parsed again on every call
+		f.modTime, f.size = info.ModTime(), info.Size()
+		var list []string
+		if list, f.err = f.load(); f.err == nil && (len(list) > 0 || f.list == nil) {
+			f.list = list
+		}
+	}
+	if err == nil {
+		err = f.err
+	}
+
+	if f.list == nil {
+		return nil, err
+	}
+	return f.list, nil
+}
+
+func (f *filereg) WatchEndpoints(ctx context.Context) (<-chan []string, error) {
+	last, err := f.ListEndpoints(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan []string, 1)
+	ch <- last
+	go func() {
+		defer close(ch)
+
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			list, err := f.ListEndpoints(ctx, false)
+			if err != nil || !listsDifferent(last, list) {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- list:
+				last = list
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (f *filereg) load() ([]string, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []string{}
+	switch filepath.Ext(f.path) {
+	case ".json":
+		err = json.Unmarshal(data, &list)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &list)
+	default:
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				list = append(list, line)
+			}
+		}
+		err = scanner.Err()
+	}
+	return list, err
+}
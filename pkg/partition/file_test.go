@@ -0,0 +1,90 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "partition")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"eps.json": `["a:1", "b:2"]`,
+		"eps.yaml": "- a:1\n- b:2\n",
+		"eps.txt":  "# comment\na:1\n\n  b:2\n",
+	}
+	expected := []string{"a:1", "b:2"}
+
+	ctx := context.Background()
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		list, err := NewFileRegistry(path).ListEndpoints(ctx, false)
+		if err != nil || !reflect.DeepEqual(list, expected) {
+			t.Error("unexpected", name, list, err)
+		}
+	}
+
+	if _, err := NewFileRegistry(filepath.Join(dir, "missing")).ListEndpoints(ctx, false); err == nil {
+		t.Error("missing file succeeded")
+	}
+}
+
+func TestFileRegistryReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "partition")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "eps.json")
+	if err := ioutil.WriteFile(path, []byte(`["a"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewFileRegistry(path).(*filereg)
+	r.interval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := r.WatchEndpoints(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := <-ch; !reflect.DeepEqual(list, []string{"a"}) {
+		t.Fatal("unexpected", list)
+	}
+
+	// invalid and empty contents are ignored
+	for _, contents := range []string{`["a", `, ``, `[]`} {
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if list, err := r.ListEndpoints(ctx, false); err != nil || !reflect.DeepEqual(list, []string{"a"}) {
+			t.Fatal("unexpected", contents, list, err)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`["a", "b"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if list := <-ch; !reflect.DeepEqual(list, []string{"a", "b"}) {
+		t.Fatal("unexpected", list)
+	}
+}
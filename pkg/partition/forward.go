@@ -0,0 +1,66 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"sync"
+)
+
+// forward runs a misrouted request on the owner known to the local
+// endpoint (see WithForwarding). The original error is returned if
+// forwarding is disabled, the owner is not one of the known
+// endpoints or the request has already been forwarded too many
+// times.
+func (s safe) forward(ctx context.Context, h header, hash uint64, input []byte, err error) ([]byte, error) {
+	owner := s.forwardTo(h, err)
+	if owner == "" {
+		return nil, err
+	}
+	return s.runOn(header{hops: h.hops + 1}.attach(ctx), owner, hash, input)
+}
+
+// forwardBatch forwards the misrouted items with one batch per
+// owner, updating results in place. The errors are the ones returned
+// by the ownership check of each item and are used as the result of
+// the items which are not forwarded.
+func (s safe) forwardBatch(ctx context.Context, h header, items []Item, misrouted map[int]error, results []Result) {
+	groups := map[string][]int{}
+	for idx, err := range misrouted {
+		if owner := s.forwardTo(h, err); owner != "" {
+			groups[owner] = append(groups[owner], idx)
+		} else {
+			results[idx].Err = err
+		}
+	}
+
+	ctx = header{hops: h.hops + 1}.attach(ctx)
+	var wg sync.WaitGroup
+	for owner, indices := range groups {
+		wg.Add(1)
+		go func(owner string, indices []int) {
+			defer wg.Done()
+
+			batch := make([]Item, len(indices))
+			for kk, idx := range indices {
+				batch[kk] = items[idx]
+			}
+			for kk, result := range s.runBatchOn(ctx, owner, batch) {
+				results[indices[kk]] = result
+			}
+		}(owner, indices)
+	}
+	wg.Wait()
+}
+
+// forwardTo returns the owner to forward a misrouted request to or
+// an empty string if it should not be forwarded.
+func (s safe) forwardTo(h header, err error) string {
+	e, ok := err.(IncorrectPartitionError)
+	if !ok || e.Owner == "" || h.hops >= s.maxHops {
+		return ""
+	}
+	return s.redirectTo(e)
+}
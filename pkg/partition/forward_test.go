@@ -0,0 +1,59 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tvastar/cluster/pkg/partition"
+	"github.com/tvastar/cluster/pkg/partition/partitiontest"
+)
+
+func TestForwarding(t *testing.T) {
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	opts := []partition.Option{
+		partition.WithEndpointRegistry(reg),
+		partition.WithNetwork(nw),
+		partition.WithForwarding(1),
+	}
+
+	routers := []partition.Router{}
+	for _, addr := range []string{"one", "two"} {
+		r, err := partition.New(ctx, addr, owner(addr), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		routers = append(routers, r)
+	}
+
+	hash := uint64(0)
+	for ; pick([]string{"one", "two"}, hash) != "two"; hash++ {
+	}
+	converge(t, routers, hash, "two")
+
+	// the client never sees "two" and does not retry, so "one"
+	// must forward the request
+	client, err := partition.New(ctx, "client", nil,
+		partition.WithNetwork(nw),
+		partition.WithEndpointRegistry(partition.NewStaticRegistry([]string{"one"})),
+		partition.WithRetryPolicy(partition.RetryPolicy{MaxAttempts: 1}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if res, err := client.Run(ctx, hash, []byte("x")); err != nil || string(res) != "two x" {
+		t.Fatal("unexpected", string(res), err)
+	}
+
+	results, err := client.RunBatch(ctx, []partition.Item{{Hash: hash, Input: []byte("y")}})
+	if err != nil || results[0].Err != nil || string(results[0].Response) != "two y" {
+		t.Fatal("unexpected", results, err)
+	}
+}
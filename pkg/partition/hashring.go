@@ -35,22 +35,34 @@ func (h *hashring) Pick(ctx context.Context, list []string, hash uint64) string
 		return ""
 	}
 
-	hash32 := crc32.ChecksumIEEE([]byte(fmt.Sprint(hash)))
-	hashes, lookup := h.getSortedHashes(list)
+	hashes, lookup := h.local(ctx).getSortedHashes(list)
+	return lookup[hashes[ringIndex(hashes, hash)]]
+}
 
+// local returns the ring to use with the context (see pickerState).
+func (h *hashring) local(ctx context.Context) *hashring {
+	create := func() interface{} { return &hashring{factor: h.factor} }
+	if ring := localState(ctx, h, create); ring != nil {
+		return ring.(*hashring)
+	}
+	return h
+}
+
+// ringIndex returns the position of the hash on the ring
+func ringIndex(hashes []uint32, hash uint64) int {
+	hash32 := crc32.ChecksumIEEE([]byte(fmt.Sprint(hash)))
 	idx := sort.Search(len(hashes), func(i int) bool { return hashes[i] >= hash32 })
 	if idx == len(hashes) {
 		idx = 0
 	}
-
-	return lookup[hashes[idx]]
+	return idx
 }
 
 func (h *hashring) getSortedHashes(list []string) ([]uint32, map[uint32]string) {
 	h.Lock()
 	defer h.Unlock()
 
-	if h.listsDifferent(h.list, list) {
+	if listsDifferent(h.list, list) {
 		h.list = list
 		h.hashes, h.lookup = h.calculateSortedHashes(list)
 	}
@@ -72,7 +84,7 @@ func (h *hashring) calculateSortedHashes(list []string) ([]uint32, map[uint32]st
 	return hashes, dict
 }
 
-func (h *hashring) listsDifferent(l1, l2 []string) bool {
+func listsDifferent(l1, l2 []string) bool {
 	if len(l1) != len(l2) {
 		return true
 	}
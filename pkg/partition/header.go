@@ -0,0 +1,48 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import "context"
+
+// header is the routing information sent along with a request, such
+// as whether it is a broadcast, a hedged or a handoff request, the
+// membership version of the caller and the number of times the
+// request has been forwarded.
+//
+// The router attaches the header to the context right before
+// sending a request and the receiving endpoint removes it before
+// calling the handler, so requests made by the handler with its
+// context never inherit the header.
+type header struct {
+	broadcast bool
+	hedged    bool
+	handoff   bool
+	epoch     uint64
+	hops      int
+}
+
+// headerKey is the context key for the header of a request.
+type headerKey struct{}
+
+func headerOf(ctx context.Context) header {
+	h, _ := ctx.Value(headerKey{}).(header)
+	return h
+}
+
+// attach adds the header to the context of an outgoing request.
+func (h header) attach(ctx context.Context) context.Context {
+	return context.WithValue(ctx, headerKey{}, h)
+}
+
+// receive removes the header from the context of an incoming
+// request. The handler can still inspect the parts of the header
+// which are exposed via IsBroadcast and IsHandoff.
+func receive(ctx context.Context) (context.Context, header) {
+	h := headerOf(ctx)
+	ctx = header{}.attach(ctx)
+	ctx = context.WithValue(ctx, broadcastKey{}, h.broadcast)
+	ctx = context.WithValue(ctx, handoffKey{}, h.handoff)
+	return ctx, h
+}
@@ -0,0 +1,145 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// hedgeSamples is the number of recent latencies used for
+// percentile-based hedging.
+const hedgeSamples = 256
+
+// hedgeMinSamples is the number of latencies needed before the
+// percentile is used instead of the fixed delay.
+const hedgeMinSamples = 20
+
+// runHedged runs the request on the primary endpoint and also on
+// the next ranked endpoint if the primary has not responded within
+// the hedging delay. The first successful response is returned and
+// the other request is canceled.
+//
+// The latency of successful requests is recorded for percentile
+// based hedging. It is timed from the start of the primary request
+// so that requests won by the hedge still count (as a lower bound
+// of the latency of the primary) instead of skewing the percentile
+// towards fast requests.
+func (s *state) runHedged(ctx context.Context, primary string, hash uint64, input []byte, refresh bool) (output []byte, err error) {
+	start := time.Now()
+	defer func() {
+		if err == nil && s.hedgePercentile > 0 {
+			s.latencies.add(time.Since(start))
+		}
+	}()
+
+	delay := s.hedgeDelay()
+	if delay <= 0 {
+		return s.runOn(ctx, primary, hash, input)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type reply struct {
+		output []byte
+		err    error
+	}
+
+	replies := make(chan reply, 2)
+	go func() {
+		output, err := s.runOn(ctx, primary, hash, input)
+		replies <- reply{output, err}
+	}()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case r := <-replies:
+		return r.output, r.err
+	case <-timer.C:
+	}
+
+	secondary := ""
+	if owners, err := s.getOwners(ctx, hash, 2, refresh); err == nil {
+		for _, owner := range owners {
+			if owner != primary {
+				secondary = owner
+				break
+			}
+		}
+	}
+	if secondary == "" {
+		r := <-replies
+		return r.output, r.err
+	}
+
+	hedged := headerOf(ctx)
+	hedged.hedged = true
+	go func() {
+		output, err := s.runOn(hedged.attach(ctx), secondary, hash, input)
+		replies <- reply{output, err}
+	}()
+
+	first := <-replies
+	if first.err == nil {
+		return first.output, nil
+	}
+	if second := <-replies; second.err == nil {
+		return second.output, nil
+	}
+	return first.output, first.err
+}
+
+// hedgeDelay returns the delay before a hedged request is sent. Zero
+// disables hedging.
+func (s *state) hedgeDelay() time.Duration {
+	if s.hedgePercentile > 0 {
+		if d, ok := s.latencies.percentile(s.hedgePercentile); ok {
+			return d
+		}
+	}
+	return s.hedgeAfter
+}
+
+func (s *state) hedging() bool {
+	return s.hedgeAfter > 0 || s.hedgePercentile > 0
+}
+
+// latencies tracks the most recent request latencies
+type latencies struct {
+	sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	if len(l.samples) < hedgeSamples {
+		l.samples = append(l.samples, d)
+		return
+	}
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % hedgeSamples
+}
+
+func (l *latencies) percentile(p float64) (time.Duration, bool) {
+	l.Lock()
+	sorted := append([]time.Duration(nil), l.samples...)
+	l.Unlock()
+
+	if len(sorted) < hedgeMinSamples {
+		return 0, false
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(p / 100 * float64(len(sorted)))
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx], true
+}
@@ -0,0 +1,114 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHedging(t *testing.T) {
+	list := []string{"a", "b", "c"}
+	ranked := NewRanker()(context.Background(), list, 5, 2)
+	reg := stubRegistry{false: list, true: list}
+	nw := stubNetwork{
+		ranked[0]: stubRunner(func() ([]byte, error) {
+			time.Sleep(200 * time.Millisecond)
+			return []byte(ranked[0]), nil
+		}),
+		ranked[1]: stubRunner(func() ([]byte, error) { return []byte(ranked[1]), nil }),
+	}
+	ctx := context.Background()
+
+	r, err := New(ctx, "", nil, WithEndpointRegistry(reg), WithNetwork(nw), WithHedging(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	start := time.Now()
+	if res, err := r.Run(ctx, 5, nil); err != nil || string(res) != ranked[1] {
+		t.Fatal("unexpected", string(res), err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Fatal("hedged request was not used", time.Since(start))
+	}
+}
+
+func TestHedgedOwnership(t *testing.T) {
+	list := []string{"a", "b", "c"}
+	ranked := NewRanker()(context.Background(), list, 5, 2)
+	reg := stubRegistry{false: list, true: list}
+	handler := stubRunner(func() ([]byte, error) { return []byte("ok"), nil })
+	ctx := context.Background()
+
+	// the receiver does not need hedging enabled
+	r, err := New(ctx, ranked[1], handler, WithEndpointRegistry(reg), WithNetwork(stubNetwork{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	receiver := safe{r.(*state)}
+	if _, err := receiver.Run(ctx, 5, nil); err != (IncorrectPartitionError{Owner: ranked[0]}) {
+		t.Fatal("unmarked request accepted by second ranked endpoint", err)
+	}
+	hedged := header{hedged: true}.attach(ctx)
+	if res, err := receiver.Run(hedged, 5, nil); err != nil || string(res) != "ok" {
+		t.Fatal("unexpected", string(res), err)
+	}
+}
+
+func TestHedgedLatency(t *testing.T) {
+	list := []string{"a", "b", "c"}
+	ranked := NewRanker()(context.Background(), list, 5, 2)
+	reg := stubRegistry{false: list, true: list}
+	unblock := make(chan struct{})
+	defer close(unblock)
+	nw := stubNetwork{
+		ranked[0]: stubRunner(func() ([]byte, error) {
+			<-unblock
+			return nil, context.Canceled
+		}),
+		ranked[1]: stubRunner(func() ([]byte, error) { return []byte(ranked[1]), nil }),
+	}
+	ctx := context.Background()
+
+	delay := 10 * time.Millisecond
+	opts := []Option{WithEndpointRegistry(reg), WithNetwork(nw), WithHedging(delay), WithHedgingPercentile(50)}
+	r, err := New(ctx, "", nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if res, err := r.Run(ctx, 5, nil); err != nil || string(res) != ranked[1] {
+		t.Fatal("unexpected", string(res), err)
+	}
+
+	// the request won by the hedge is timed from the start of the
+	// primary request
+	l := &r.(*state).latencies
+	l.Lock()
+	defer l.Unlock()
+	if len(l.samples) != 1 || l.samples[0] < delay {
+		t.Fatal("unexpected latencies", l.samples)
+	}
+}
+
+func TestLatencyPercentile(t *testing.T) {
+	l := &latencies{}
+	if _, ok := l.percentile(50); ok {
+		t.Fatal("unexpected percentile with no samples")
+	}
+
+	for kk := 1; kk <= 2*hedgeSamples; kk++ {
+		l.add(time.Duration(kk))
+	}
+	if d, ok := l.percentile(50); !ok || d != hedgeSamples+hedgeSamples/2+1 {
+		t.Fatal("unexpected percentile", d, ok)
+	}
+}
@@ -28,6 +28,11 @@ const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 type RunRequest struct {
 	Input                []byte   `protobuf:"bytes,1,opt,name=input,proto3" json:"input,omitempty"`
 	Hash                 uint64   `protobuf:"varint,2,opt,name=hash,proto3" json:"hash,omitempty"`
+	Broadcast            bool     `protobuf:"varint,3,opt,name=broadcast,proto3" json:"broadcast,omitempty"`
+	Handoff              bool     `protobuf:"varint,4,opt,name=handoff,proto3" json:"handoff,omitempty"`
+	Epoch                uint64   `protobuf:"varint,5,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	Hops                 uint32   `protobuf:"varint,6,opt,name=hops,proto3" json:"hops,omitempty"`
+	Hedged               bool     `protobuf:"varint,7,opt,name=hedged,proto3" json:"hedged,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -72,9 +77,48 @@ func (m *RunRequest) GetHash() uint64 {
 	return 0
 }
 
+func (m *RunRequest) GetBroadcast() bool {
+	if m != nil {
+		return m.Broadcast
+	}
+	return false
+}
+
+func (m *RunRequest) GetHandoff() bool {
+	if m != nil {
+		return m.Handoff
+	}
+	return false
+}
+
+func (m *RunRequest) GetEpoch() uint64 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+func (m *RunRequest) GetHops() uint32 {
+	if m != nil {
+		return m.Hops
+	}
+	return 0
+}
+
+func (m *RunRequest) GetHedged() bool {
+	if m != nil {
+		return m.Hedged
+	}
+	return false
+}
+
 type RunReply struct {
 	Response             []byte   `protobuf:"bytes,1,opt,name=response,proto3" json:"response,omitempty"`
 	Error                string   `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	IncorrectPartition   bool     `protobuf:"varint,3,opt,name=incorrect_partition,json=incorrectPartition,proto3" json:"incorrect_partition,omitempty"`
+	Version              uint64   `protobuf:"varint,4,opt,name=version,proto3" json:"version,omitempty"`
+	Endpoints            []string `protobuf:"bytes,5,rep,name=endpoints,proto3" json:"endpoints,omitempty"`
+	Owner                string   `protobuf:"bytes,6,opt,name=owner,proto3" json:"owner,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -119,26 +163,351 @@ func (m *RunReply) GetError() string {
 	return ""
 }
 
+func (m *RunReply) GetIncorrectPartition() bool {
+	if m != nil {
+		return m.IncorrectPartition
+	}
+	return false
+}
+
+func (m *RunReply) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *RunReply) GetEndpoints() []string {
+	if m != nil {
+		return m.Endpoints
+	}
+	return nil
+}
+
+func (m *RunReply) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+type RunBatchRequest struct {
+	Items                []*RunRequest `protobuf:"bytes,1,rep,name=items,proto3" json:"items,omitempty"`
+	Epoch                uint64        `protobuf:"varint,2,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	Hops                 uint32        `protobuf:"varint,3,opt,name=hops,proto3" json:"hops,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *RunBatchRequest) Reset()         { *m = RunBatchRequest{} }
+func (m *RunBatchRequest) String() string { return proto.CompactTextString(m) }
+func (*RunBatchRequest) ProtoMessage()    {}
+func (*RunBatchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00212fb1f9d3bf1c, []int{2}
+}
+
+func (m *RunBatchRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RunBatchRequest.Unmarshal(m, b)
+}
+func (m *RunBatchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RunBatchRequest.Marshal(b, m, deterministic)
+}
+func (m *RunBatchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RunBatchRequest.Merge(m, src)
+}
+func (m *RunBatchRequest) XXX_Size() int {
+	return xxx_messageInfo_RunBatchRequest.Size(m)
+}
+func (m *RunBatchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RunBatchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RunBatchRequest proto.InternalMessageInfo
+
+func (m *RunBatchRequest) GetItems() []*RunRequest {
+	if m != nil {
+		return m.Items
+	}
+	return nil
+}
+
+func (m *RunBatchRequest) GetEpoch() uint64 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+func (m *RunBatchRequest) GetHops() uint32 {
+	if m != nil {
+		return m.Hops
+	}
+	return 0
+}
+
+type RunBatchReply struct {
+	Replies              []*RunReply `protobuf:"bytes,1,rep,name=replies,proto3" json:"replies,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *RunBatchReply) Reset()         { *m = RunBatchReply{} }
+func (m *RunBatchReply) String() string { return proto.CompactTextString(m) }
+func (*RunBatchReply) ProtoMessage()    {}
+func (*RunBatchReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00212fb1f9d3bf1c, []int{3}
+}
+
+func (m *RunBatchReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RunBatchReply.Unmarshal(m, b)
+}
+func (m *RunBatchReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RunBatchReply.Marshal(b, m, deterministic)
+}
+func (m *RunBatchReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RunBatchReply.Merge(m, src)
+}
+func (m *RunBatchReply) XXX_Size() int {
+	return xxx_messageInfo_RunBatchReply.Size(m)
+}
+func (m *RunBatchReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_RunBatchReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RunBatchReply proto.InternalMessageInfo
+
+func (m *RunBatchReply) GetReplies() []*RunReply {
+	if m != nil {
+		return m.Replies
+	}
+	return nil
+}
+
+type Endpoint struct {
+	Addr                 string            `protobuf:"bytes,1,opt,name=addr,proto3" json:"addr,omitempty"`
+	Zone                 string            `protobuf:"bytes,2,opt,name=zone,proto3" json:"zone,omitempty"`
+	Version              string            `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`
+	Weight               float64           `protobuf:"fixed64,4,opt,name=weight,proto3" json:"weight,omitempty"`
+	Labels               map[string]string `protobuf:"bytes,5,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *Endpoint) Reset()         { *m = Endpoint{} }
+func (m *Endpoint) String() string { return proto.CompactTextString(m) }
+func (*Endpoint) ProtoMessage()    {}
+func (*Endpoint) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00212fb1f9d3bf1c, []int{4}
+}
+
+func (m *Endpoint) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Endpoint.Unmarshal(m, b)
+}
+func (m *Endpoint) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Endpoint.Marshal(b, m, deterministic)
+}
+func (m *Endpoint) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Endpoint.Merge(m, src)
+}
+func (m *Endpoint) XXX_Size() int {
+	return xxx_messageInfo_Endpoint.Size(m)
+}
+func (m *Endpoint) XXX_DiscardUnknown() {
+	xxx_messageInfo_Endpoint.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Endpoint proto.InternalMessageInfo
+
+func (m *Endpoint) GetAddr() string {
+	if m != nil {
+		return m.Addr
+	}
+	return ""
+}
+
+func (m *Endpoint) GetZone() string {
+	if m != nil {
+		return m.Zone
+	}
+	return ""
+}
+
+func (m *Endpoint) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+func (m *Endpoint) GetWeight() float64 {
+	if m != nil {
+		return m.Weight
+	}
+	return 0
+}
+
+func (m *Endpoint) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+type MigrateRequest struct {
+	Target               string      `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	Endpoints            []*Endpoint `protobuf:"bytes,2,rep,name=endpoints,proto3" json:"endpoints,omitempty"`
+	Old                  []*Endpoint `protobuf:"bytes,3,rep,name=old,proto3" json:"old,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *MigrateRequest) Reset()         { *m = MigrateRequest{} }
+func (m *MigrateRequest) String() string { return proto.CompactTextString(m) }
+func (*MigrateRequest) ProtoMessage()    {}
+func (*MigrateRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00212fb1f9d3bf1c, []int{5}
+}
+
+func (m *MigrateRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MigrateRequest.Unmarshal(m, b)
+}
+func (m *MigrateRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MigrateRequest.Marshal(b, m, deterministic)
+}
+func (m *MigrateRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MigrateRequest.Merge(m, src)
+}
+func (m *MigrateRequest) XXX_Size() int {
+	return xxx_messageInfo_MigrateRequest.Size(m)
+}
+func (m *MigrateRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_MigrateRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MigrateRequest proto.InternalMessageInfo
+
+func (m *MigrateRequest) GetTarget() string {
+	if m != nil {
+		return m.Target
+	}
+	return ""
+}
+
+func (m *MigrateRequest) GetEndpoints() []*Endpoint {
+	if m != nil {
+		return m.Endpoints
+	}
+	return nil
+}
+
+func (m *MigrateRequest) GetOld() []*Endpoint {
+	if m != nil {
+		return m.Old
+	}
+	return nil
+}
+
+type MigrateReply struct {
+	Hash                 uint64   `protobuf:"varint,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	Data                 []byte   `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MigrateReply) Reset()         { *m = MigrateReply{} }
+func (m *MigrateReply) String() string { return proto.CompactTextString(m) }
+func (*MigrateReply) ProtoMessage()    {}
+func (*MigrateReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00212fb1f9d3bf1c, []int{6}
+}
+
+func (m *MigrateReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MigrateReply.Unmarshal(m, b)
+}
+func (m *MigrateReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MigrateReply.Marshal(b, m, deterministic)
+}
+func (m *MigrateReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MigrateReply.Merge(m, src)
+}
+func (m *MigrateReply) XXX_Size() int {
+	return xxx_messageInfo_MigrateReply.Size(m)
+}
+func (m *MigrateReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_MigrateReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MigrateReply proto.InternalMessageInfo
+
+func (m *MigrateReply) GetHash() uint64 {
+	if m != nil {
+		return m.Hash
+	}
+	return 0
+}
+
+func (m *MigrateReply) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*RunRequest)(nil), "rpc.RunRequest")
 	proto.RegisterType((*RunReply)(nil), "rpc.RunReply")
+	proto.RegisterType((*RunBatchRequest)(nil), "rpc.RunBatchRequest")
+	proto.RegisterType((*RunBatchReply)(nil), "rpc.RunBatchReply")
+	proto.RegisterType((*Endpoint)(nil), "rpc.Endpoint")
+	proto.RegisterMapType((map[string]string)(nil), "rpc.Endpoint.LabelsEntry")
+	proto.RegisterType((*MigrateRequest)(nil), "rpc.MigrateRequest")
+	proto.RegisterType((*MigrateReply)(nil), "rpc.MigrateReply")
 }
 
 func init() { proto.RegisterFile("api.proto", fileDescriptor_00212fb1f9d3bf1c) }
 
 var fileDescriptor_00212fb1f9d3bf1c = []byte{
-	// 165 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x44, 0x8e, 0xc1, 0xca, 0x82, 0x50,
-	0x10, 0x46, 0x7f, 0x7f, 0x4d, 0x74, 0x28, 0x82, 0xa1, 0x85, 0xb8, 0x12, 0x37, 0xb9, 0x12, 0x2a,
-	0x68, 0xd5, 0x53, 0xcc, 0x1b, 0x98, 0x5c, 0x50, 0x90, 0xb9, 0xd3, 0x5c, 0xef, 0xc2, 0xb7, 0x8f,
-	0xae, 0x91, 0xbb, 0x39, 0x03, 0xe7, 0xe3, 0x40, 0xde, 0xc9, 0xd8, 0x8a, 0xda, 0xd9, 0x62, 0xac,
-	0xd2, 0xd7, 0x77, 0x00, 0xf2, 0x4c, 0xe6, 0xe5, 0x8d, 0x9b, 0xf1, 0x04, 0xbb, 0x91, 0xc5, 0xcf,
-	0x45, 0x54, 0x45, 0xcd, 0x9e, 0x56, 0x40, 0x84, 0x64, 0xe8, 0xdc, 0x50, 0xfc, 0x57, 0x51, 0x93,
-	0x50, 0xb8, 0xeb, 0x07, 0x64, 0xc1, 0x93, 0x69, 0xc1, 0x12, 0x32, 0x35, 0x4e, 0x2c, 0x3b, 0xf3,
-	0x15, 0x7f, 0xfc, 0x59, 0x34, 0xaa, 0x56, 0x83, 0x9c, 0xd3, 0x0a, 0xd7, 0x0b, 0xa4, 0xe4, 0x99,
-	0x8d, 0xe2, 0x19, 0x62, 0xf2, 0x8c, 0xc7, 0x56, 0xa5, 0x6f, 0xb7, 0x92, 0xf2, 0xb0, 0x3d, 0x64,
-	0x5a, 0xea, 0xbf, 0x67, 0x1a, 0xa2, 0x6f, 0xef, 0x00, 0x00, 0x00, 0xff, 0xff, 0x2a, 0x07, 0x3c,
-	0xe4, 0xc1, 0x00, 0x00, 0x00,
+	// 554 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x54, 0xd1, 0x6e, 0xd3, 0x30,
+	0x14, 0xc5, 0x4d, 0x9b, 0x36, 0x77, 0x1b, 0x03, 0x6f, 0x9a, 0x42, 0x85, 0x44, 0x14, 0x09, 0x2d,
+	0x12, 0x52, 0x81, 0x21, 0xa6, 0xc1, 0x23, 0xd2, 0xde, 0x40, 0x42, 0xfe, 0x01, 0xe4, 0x26, 0x5e,
+	0x13, 0x11, 0x6c, 0xe3, 0x38, 0x9b, 0xca, 0xd7, 0xf0, 0x09, 0xbc, 0xf3, 0x17, 0x7c, 0x11, 0xf2,
+	0x4d, 0xdc, 0xa4, 0xd3, 0xde, 0xee, 0xb9, 0xc7, 0xf1, 0x39, 0xf7, 0xdc, 0x24, 0x10, 0x71, 0x5d,
+	0xad, 0xb4, 0x51, 0x56, 0xd1, 0xc0, 0xe8, 0x3c, 0xfd, 0x43, 0x00, 0x58, 0x2b, 0x99, 0xf8, 0xd9,
+	0x8a, 0xc6, 0xd2, 0x53, 0x98, 0x55, 0x52, 0xb7, 0x36, 0x26, 0x09, 0xc9, 0x0e, 0x59, 0x07, 0x28,
+	0x85, 0x69, 0xc9, 0x9b, 0x32, 0x9e, 0x24, 0x24, 0x9b, 0x32, 0xac, 0xe9, 0x73, 0x88, 0xd6, 0x46,
+	0xf1, 0x22, 0xe7, 0x8d, 0x8d, 0x83, 0x84, 0x64, 0x0b, 0x36, 0x34, 0x68, 0x0c, 0xf3, 0x92, 0xcb,
+	0x42, 0xdd, 0xdc, 0xc4, 0x53, 0xe4, 0x3c, 0x74, 0x0a, 0x42, 0xab, 0xbc, 0x8c, 0x67, 0x78, 0x59,
+	0x07, 0x50, 0x41, 0xe9, 0x26, 0x0e, 0x13, 0x92, 0x1d, 0x31, 0xac, 0xe9, 0x19, 0x84, 0xa5, 0x28,
+	0x36, 0xa2, 0x88, 0xe7, 0x78, 0x45, 0x8f, 0xd2, 0xbf, 0x04, 0x16, 0x68, 0x59, 0xd7, 0x5b, 0xba,
+	0x84, 0x85, 0x11, 0x8d, 0x56, 0xb2, 0x11, 0xbd, 0xe7, 0x1d, 0x46, 0x29, 0x63, 0x94, 0x41, 0xdf,
+	0x11, 0xeb, 0x00, 0x7d, 0x0d, 0x27, 0x95, 0xcc, 0x95, 0x31, 0x22, 0xb7, 0xdf, 0x34, 0x37, 0xb6,
+	0xb2, 0x95, 0x92, 0xfd, 0x08, 0x74, 0x47, 0x7d, 0xf5, 0x8c, 0x9b, 0xe5, 0x56, 0x98, 0xc6, 0x1d,
+	0x9a, 0xa2, 0x67, 0x0f, 0x5d, 0x06, 0x42, 0x16, 0x5a, 0x55, 0xd2, 0x36, 0xf1, 0x2c, 0x09, 0xb2,
+	0x88, 0x0d, 0x0d, 0x27, 0xaf, 0xee, 0xa4, 0x30, 0x38, 0x54, 0xc4, 0x3a, 0x90, 0xae, 0xe1, 0x98,
+	0xb5, 0xf2, 0x13, 0xb7, 0x79, 0xe9, 0x43, 0x7f, 0x09, 0xb3, 0xca, 0x8a, 0x1f, 0x4d, 0x4c, 0x92,
+	0x20, 0x3b, 0xb8, 0x38, 0x5e, 0x19, 0x9d, 0xaf, 0x86, 0xa5, 0xb0, 0x8e, 0x1d, 0x92, 0x9b, 0x3c,
+	0x94, 0x5c, 0x30, 0x24, 0x97, 0x5e, 0xc1, 0xd1, 0xa0, 0xe1, 0x52, 0x3a, 0x87, 0xb9, 0x11, 0xba,
+	0xae, 0x84, 0xd7, 0x38, 0x1a, 0x34, 0x74, 0xbd, 0x65, 0x9e, 0x4d, 0xff, 0x11, 0x58, 0x5c, 0xf7,
+	0x13, 0xb8, 0xab, 0x79, 0x51, 0x18, 0xcc, 0x35, 0x62, 0x58, 0xbb, 0xde, 0x2f, 0x25, 0x45, 0x1f,
+	0x29, 0xd6, 0xe3, 0x80, 0x02, 0x6c, 0x7b, 0xe8, 0x56, 0x78, 0x27, 0xaa, 0x4d, 0x69, 0x31, 0x39,
+	0xc2, 0x7a, 0x44, 0xdf, 0x42, 0x58, 0xf3, 0xb5, 0xa8, 0xbb, 0xd4, 0x0e, 0x2e, 0x9e, 0xa1, 0x1d,
+	0x2f, 0xbc, 0xfa, 0x8c, 0xdc, 0xb5, 0xb4, 0x66, 0xcb, 0xfa, 0x83, 0xcb, 0x0f, 0x70, 0x30, 0x6a,
+	0xd3, 0x27, 0x10, 0x7c, 0x17, 0xdb, 0xde, 0x9a, 0x2b, 0x5d, 0x3c, 0xb7, 0xbc, 0x6e, 0xbd, 0xb5,
+	0x0e, 0x7c, 0x9c, 0x5c, 0x91, 0xf4, 0x16, 0x1e, 0x7f, 0xa9, 0x36, 0x86, 0x5b, 0xe1, 0x13, 0x3f,
+	0x83, 0xd0, 0x72, 0xb3, 0x11, 0xb6, 0xbf, 0xa0, 0x47, 0xf4, 0xd5, 0x78, 0xa1, 0x93, 0x51, 0x52,
+	0xde, 0xda, 0x78, 0xbf, 0x2f, 0x20, 0x50, 0x75, 0x11, 0x07, 0x0f, 0x1d, 0x73, 0x4c, 0x7a, 0x09,
+	0x87, 0x3b, 0x5d, 0xb7, 0x05, 0xff, 0x19, 0x91, 0xd1, 0x67, 0x44, 0x61, 0x5a, 0x70, 0xcb, 0xd1,
+	0xf4, 0x21, 0xc3, 0xfa, 0xe2, 0x37, 0x81, 0x90, 0xb5, 0x52, 0x0a, 0x43, 0xcf, 0x21, 0x60, 0xad,
+	0xa4, 0xf7, 0x5f, 0x89, 0xe5, 0xfe, 0xfe, 0xd2, 0x47, 0xf4, 0x12, 0x16, 0x7e, 0xe5, 0xf4, 0xd4,
+	0x93, 0xe3, 0xb7, 0x6c, 0x49, 0xef, 0x75, 0xbb, 0xe7, 0xde, 0xc3, 0xbc, 0xf7, 0x48, 0x4f, 0xf0,
+	0xc0, 0x7e, 0x52, 0xcb, 0xa7, 0xfb, 0x4d, 0x7c, 0xe8, 0x0d, 0x59, 0x87, 0xf8, 0x0b, 0x79, 0xf7,
+	0x7f, 0x00, 0xf6, 0xff, 0x30, 0x67, 0x4f, 0x04, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -154,6 +523,8 @@ const _ = grpc.SupportPackageIsVersion4
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type RunnerClient interface {
 	Run(ctx context.Context, in *RunRequest, opts ...grpc.CallOption) (*RunReply, error)
+	RunBatch(ctx context.Context, in *RunBatchRequest, opts ...grpc.CallOption) (*RunBatchReply, error)
+	Migrate(ctx context.Context, in *MigrateRequest, opts ...grpc.CallOption) (Runner_MigrateClient, error)
 }
 
 type runnerClient struct {
@@ -173,9 +544,52 @@ func (c *runnerClient) Run(ctx context.Context, in *RunRequest, opts ...grpc.Cal
 	return out, nil
 }
 
+func (c *runnerClient) RunBatch(ctx context.Context, in *RunBatchRequest, opts ...grpc.CallOption) (*RunBatchReply, error) {
+	out := new(RunBatchReply)
+	err := c.cc.Invoke(ctx, "/rpc.Runner/RunBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *runnerClient) Migrate(ctx context.Context, in *MigrateRequest, opts ...grpc.CallOption) (Runner_MigrateClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Runner_serviceDesc.Streams[0], "/rpc.Runner/Migrate", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &runnerMigrateClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Runner_MigrateClient interface {
+	Recv() (*MigrateReply, error)
+	grpc.ClientStream
+}
+
+type runnerMigrateClient struct {
+	grpc.ClientStream
+}
+
+func (x *runnerMigrateClient) Recv() (*MigrateReply, error) {
+	m := new(MigrateReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // RunnerServer is the server API for Runner service.
 type RunnerServer interface {
 	Run(context.Context, *RunRequest) (*RunReply, error)
+	RunBatch(context.Context, *RunBatchRequest) (*RunBatchReply, error)
+	Migrate(*MigrateRequest, Runner_MigrateServer) error
 }
 
 // UnimplementedRunnerServer can be embedded to have forward compatible implementations.
@@ -185,6 +599,12 @@ type UnimplementedRunnerServer struct {
 func (*UnimplementedRunnerServer) Run(ctx context.Context, req *RunRequest) (*RunReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Run not implemented")
 }
+func (*UnimplementedRunnerServer) RunBatch(ctx context.Context, req *RunBatchRequest) (*RunBatchReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RunBatch not implemented")
+}
+func (*UnimplementedRunnerServer) Migrate(req *MigrateRequest, srv Runner_MigrateServer) error {
+	return status.Errorf(codes.Unimplemented, "method Migrate not implemented")
+}
 
 func RegisterRunnerServer(s *grpc.Server, srv RunnerServer) {
 	s.RegisterService(&_Runner_serviceDesc, srv)
@@ -208,6 +628,45 @@ func _Runner_Run_Handler(srv interface{}, ctx context.Context, dec func(interfac
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Runner_RunBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RunBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RunnerServer).RunBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.Runner/RunBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RunnerServer).RunBatch(ctx, req.(*RunBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Runner_Migrate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(MigrateRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RunnerServer).Migrate(m, &runnerMigrateServer{stream})
+}
+
+type Runner_MigrateServer interface {
+	Send(*MigrateReply) error
+	grpc.ServerStream
+}
+
+type runnerMigrateServer struct {
+	grpc.ServerStream
+}
+
+func (x *runnerMigrateServer) Send(m *MigrateReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _Runner_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "rpc.Runner",
 	HandlerType: (*RunnerServer)(nil),
@@ -216,7 +675,17 @@ var _Runner_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Run",
 			Handler:    _Runner_Run_Handler,
 		},
+		{
+			MethodName: "RunBatch",
+			Handler:    _Runner_RunBatch_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Migrate",
+			Handler:       _Runner_Migrate_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "api.proto",
 }
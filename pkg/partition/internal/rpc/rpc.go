@@ -15,29 +15,17 @@ import (
 
 //go:generate protoc -I . ./api.proto --go_out=plugins=grpc:.
 
-// Runner executes a single request with the specified hash
-type Runner interface {
-	Run(ctx context.Context, hash uint64, input []byte) ([]byte, error)
-}
-
-// RunCloser combines Runner and io.Closer
-type RunCloser interface {
-	Run(ctx context.Context, hash uint64, input []byte) ([]byte, error)
-	Close() error
-	//io.Closer
-}
-
 // DialClient creates a RPC client
-func DialClient(ctx context.Context, addr string) (client, error) {
+func DialClient(ctx context.Context, addr string) (Client, error) {
 	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure())
 	if err != nil {
-		return client{}, err
+		return Client{}, err
 	}
-	return client{conn}, nil
+	return Client{conn}, nil
 }
 
 // RegisterServer registers an RPC handler
-func RegisterServer(ctx context.Context, srv *grpc.Server, addr string, handler Runner) (io.Closer, error) {
+func RegisterServer(ctx context.Context, srv *grpc.Server, addr string, handler RunnerServer) (io.Closer, error) {
 	var listener net.Listener
 	if srv == nil {
 		l, err := net.Listen("tcp", addr)
@@ -46,8 +34,8 @@ func RegisterServer(ctx context.Context, srv *grpc.Server, addr string, handler
 		}
 		srv, listener = grpc.NewServer(), l
 	}
-	result := &server{srv, listener, handler}
-	RegisterRunnerServer(srv, result)
+	result := &server{srv, listener}
+	RegisterRunnerServer(srv, handler)
 
 	if listener != nil {
 		go func() {
@@ -60,34 +48,30 @@ func RegisterServer(ctx context.Context, srv *grpc.Server, addr string, handler
 	return result, nil
 }
 
-type client struct {
+// Client is a RunnerClient which holds on to the underlying
+// connection.
+type Client struct {
 	*grpc.ClientConn
 }
 
-func (c client) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
-	rc := runnerClient{c.ClientConn}
-	reply, err := rc.Run(ctx, &RunRequest{Input: input, Hash: hash})
-	if err != nil {
-		return nil, err
-	}
-	if reply.Error != "" {
-		return nil, remoteError(reply.Error)
-	}
-	return reply.Response, nil
+// Run issues a single Run RPC
+func (c Client) Run(ctx context.Context, in *RunRequest) (*RunReply, error) {
+	return NewRunnerClient(c.ClientConn).Run(ctx, in)
+}
+
+// RunBatch issues a single RunBatch RPC
+func (c Client) RunBatch(ctx context.Context, in *RunBatchRequest) (*RunBatchReply, error) {
+	return NewRunnerClient(c.ClientConn).RunBatch(ctx, in)
+}
+
+// Migrate issues a single streaming Migrate RPC
+func (c Client) Migrate(ctx context.Context, in *MigrateRequest) (Runner_MigrateClient, error) {
+	return NewRunnerClient(c.ClientConn).Migrate(ctx, in)
 }
 
 type server struct {
 	*grpc.Server
 	listener net.Listener
-	Runner
-}
-
-func (s *server) Run(ctx context.Context, in *RunRequest) (*RunReply, error) {
-	response, err := s.Runner.Run(ctx, in.Hash, in.Input)
-	if err != nil {
-		return &RunReply{Response: response, Error: err.Error()}, nil
-	}
-	return &RunReply{Response: response}, nil
 }
 
 func (s *server) Close() error {
@@ -97,9 +81,3 @@ func (s *server) Close() error {
 	}
 	return nil
 }
-
-type remoteError string
-
-func (e remoteError) Error() string {
-	return string(e)
-}
@@ -0,0 +1,101 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// NewJumpHashPicker returns a picker which uses the jump consistent
+// hash algorithm.
+//
+// Endpoints are sorted before being assigned buckets. Jump hash
+// only minimizes the remapping of hashes when the last bucket is
+// added or removed, so endpoints joining or leaving in the middle of
+// the sorted order move more hashes than with NewHashRing.
+//
+// See https://arxiv.org/abs/1406.2294
+func NewJumpHashPicker() func(ctx context.Context, list []string, hash uint64) string {
+	j := &jumphash{}
+	return j.Pick
+}
+
+type jumphash struct {
+	sortedCache
+}
+
+func (j *jumphash) Pick(ctx context.Context, list []string, hash uint64) string {
+	if len(list) == 0 {
+		return ""
+	}
+
+	sorted := j.local(ctx).get(list, func(sorted []string) interface{} { return sorted }).([]string)
+	return sorted[jump(hash, len(sorted))]
+}
+
+func jump(key uint64, buckets int) int {
+	b, j := int64(-1), int64(0)
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// sortedCache caches a value derived from a sorted copy of the list
+// of endpoints. The value is only rebuilt when the set of endpoints
+// changes, independent of the order of the list.
+type sortedCache struct {
+	sync.Mutex
+	last    []string
+	members map[string]bool
+	value   interface{}
+}
+
+func (c *sortedCache) get(list []string, build func(sorted []string) interface{}) interface{} {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.changed(list) {
+		sorted := append([]string(nil), list...)
+		sort.Strings(sorted)
+		c.value = build(sorted)
+		c.members = map[string]bool{}
+		for _, ep := range list {
+			c.members[ep] = true
+		}
+	}
+	c.last = list
+	return c.value
+}
+
+// local returns the cache to use with the context (see
+// pickerState).
+func (c *sortedCache) local(ctx context.Context) *sortedCache {
+	create := func() interface{} { return &sortedCache{} }
+	if cache := localState(ctx, c, create); cache != nil {
+		return cache.(*sortedCache)
+	}
+	return c
+}
+
+func (c *sortedCache) changed(list []string) bool {
+	switch {
+	case c.value == nil || len(list) != len(c.members):
+		return true
+	case len(list) > 0 && &list[0] == &c.last[0]:
+		return false
+	}
+
+	for _, ep := range list {
+		if !c.members[ep] {
+			return true
+		}
+	}
+	return false
+}
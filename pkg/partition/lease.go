@@ -0,0 +1,305 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LeaseStore manages exclusive, expiring leases on virtual
+// partitions (see WithLeases).
+//
+// Acquire and Renew return false if the lease is held by another
+// owner. Acquire extends leases already held by the owner.
+//
+// Acquire also returns a fencing token for the lease: the token
+// must increase every time the lease moves to a new owner and stay
+// the same while it is extended by the same owner.
+//
+// Renew and Release have no effect on leases held by other owners.
+type LeaseStore interface {
+	Acquire(ctx context.Context, partition int, owner string, ttl time.Duration) (fence uint64, ok bool, err error)
+	Renew(ctx context.Context, partition int, owner string, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, partition int, owner string) error
+}
+
+// FenceFromContext returns the fencing token of the lease under
+// which the request is running (see WithLeases).
+//
+// Storage written to by handlers can reject writes with a token
+// smaller than the largest one seen so far, as they come from an
+// owner which has since lost the lease.
+func FenceFromContext(ctx context.Context) (uint64, bool) {
+	fence, ok := ctx.Value(fenceKey{}).(uint64)
+	return fence, ok
+}
+
+// fenceKey is the context key for the fencing token.
+type fenceKey struct{}
+
+// lease is a lease held by the local endpoint
+type lease struct {
+	expiry time.Time
+	fence  uint64
+
+	// lost is closed once the lease is lost, released or expires
+	// without being renewed
+	lost  chan struct{}
+	timer *time.Timer
+}
+
+// withLease returns a context for running a request under the
+// lease. The context is canceled once the lease is lost.
+func withLease(ctx context.Context, ls lease) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-ls.lost:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return context.WithValue(ctx, fenceKey{}, ls.fence), cancel
+}
+
+// leases tracks the leases held by the local endpoint and the
+// requests running under them
+type leases struct {
+	sync.Mutex
+	held    map[int]lease // partition => lease
+	running map[int]int   // partition => number of requests
+}
+
+// use returns the lease for the partition if it is still valid and
+// counts the request as running under it until done is called.
+func (l *leases) use(partition int) (lease, bool) {
+	l.Lock()
+	defer l.Unlock()
+	ls, ok := l.held[partition]
+	if !ok || !time.Now().Before(ls.expiry) {
+		return lease{}, false
+	}
+	if l.running == nil {
+		l.running = map[int]int{}
+	}
+	l.running[partition]++
+	return ls, true
+}
+
+func (l *leases) done(partition int) {
+	l.Lock()
+	defer l.Unlock()
+	if l.running[partition]--; l.running[partition] == 0 {
+		delete(l.running, partition)
+	}
+}
+
+func (l *leases) get(partition int) (lease, bool) {
+	l.Lock()
+	defer l.Unlock()
+	ls, ok := l.held[partition]
+	return ls, ok && time.Now().Before(ls.expiry)
+}
+
+// set records an acquired lease. Acquiring a lease which is already
+// held with the same fencing token only extends it.
+func (l *leases) set(partition int, ls lease) lease {
+	l.Lock()
+	defer l.Unlock()
+	if l.held == nil {
+		l.held = map[int]lease{}
+	}
+	if current, ok := l.held[partition]; ok && current.fence == ls.fence {
+		if ls.expiry.After(current.expiry) {
+			current.expiry = ls.expiry
+			l.held[partition] = current
+		}
+		return current
+	}
+	l.end(partition)
+	ls.lost = make(chan struct{})
+	ls.timer = time.AfterFunc(time.Until(ls.expiry), func() {
+		l.expire(partition, ls.lost)
+	})
+	l.held[partition] = ls
+	return ls
+}
+
+// expire ends the lease unless it was renewed in the meantime.
+func (l *leases) expire(partition int, lost chan struct{}) {
+	l.Lock()
+	defer l.Unlock()
+	ls, ok := l.held[partition]
+	if !ok || ls.lost != lost {
+		return
+	}
+	if d := time.Until(ls.expiry); d > 0 {
+		ls.timer.Reset(d)
+		return
+	}
+	l.end(partition)
+}
+
+// end removes the lease, canceling the requests running under it.
+// This must be called with the lock held.
+func (l *leases) end(partition int) {
+	if ls, ok := l.held[partition]; ok {
+		ls.timer.Stop()
+		close(ls.lost)
+		delete(l.held, partition)
+	}
+}
+
+func (l *leases) extend(partition int, expiry time.Time) {
+	l.Lock()
+	defer l.Unlock()
+	if ls, ok := l.held[partition]; ok {
+		ls.expiry = expiry
+		l.held[partition] = ls
+	}
+}
+
+func (l *leases) remove(partition int) {
+	l.Lock()
+	defer l.Unlock()
+	l.end(partition)
+}
+
+// drop removes the lease unless requests are still running under
+// it, in which case the lease is kept until they finish.
+func (l *leases) drop(partition int) bool {
+	l.Lock()
+	defer l.Unlock()
+	if l.running[partition] > 0 {
+		return false
+	}
+	l.end(partition)
+	return true
+}
+
+func (l *leases) partitions() []int {
+	l.Lock()
+	defer l.Unlock()
+	result := make([]int, 0, len(l.held))
+	for partition := range l.held {
+		result = append(result, partition)
+	}
+	return result
+}
+
+// partitionOf maps the hash to its virtual partition when leases
+// are used.
+func (s *state) partitionOf(hash uint64) uint64 {
+	if s.leaseStore == nil {
+		return hash
+	}
+	return hash % uint64(s.leasePartitions)
+}
+
+// maintainLeases periodically acquires the leases for the
+// partitions owned by the local endpoint, renews the ones held and
+// releases the ones no longer owned once the requests running under
+// them finish.
+func (s *state) maintainLeases() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.leaseCloser = cancelcloser{cancel, done}
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.leaseTTL / 3)
+		defer ticker.Stop()
+
+		for {
+			s.updateLeases(ctx)
+			select {
+			case <-ctx.Done():
+				s.releaseLeases()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *state) updateLeases(ctx context.Context) {
+	eps, err := s.listAll(ctx, false)
+	if err != nil {
+		return
+	}
+
+	pick := s.picker()
+	for partition := 0; partition < s.leasePartitions; partition++ {
+		owned := pick(ctx, eps, uint64(partition)) == s.addr
+		_, held := s.leases.get(partition)
+		switch {
+		case owned && held:
+			start := time.Now()
+			if ok, err := s.leaseStore.Renew(ctx, partition, s.addr, s.leaseTTL); ok && err == nil {
+				s.leases.extend(partition, start.Add(s.leaseTTL))
+			} else if err == nil {
+				s.leases.remove(partition)
+			}
+		case owned:
+			s.acquireLease(ctx, partition)
+		case held:
+			s.releaseLease(ctx, partition)
+		}
+	}
+}
+
+// acquireLease acquires the lease for the partition.
+func (s *state) acquireLease(ctx context.Context, partition int) (lease, bool) {
+	start := time.Now()
+	fence, ok, err := s.leaseStore.Acquire(ctx, partition, s.addr, s.leaseTTL)
+	if !ok || err != nil {
+		return lease{}, false
+	}
+	return s.leases.set(partition, lease{expiry: start.Add(s.leaseTTL), fence: fence}), true
+}
+
+// releaseLease releases the lease for the partition unless requests
+// are still running under it. Such leases are retried on the next
+// update or left to expire if the router is closed, which cancels
+// the requests.
+func (s *state) releaseLease(ctx context.Context, partition int) {
+	if s.leases.drop(partition) {
+		s.leaseStore.Release(ctx, partition, s.addr)
+	}
+}
+
+func (s *state) releaseLeases() {
+	ctx := context.Background()
+	for _, partition := range s.leases.partitions() {
+		s.releaseLease(ctx, partition)
+	}
+}
+
+// lease returns the lease for the hash, acquiring it if needed. The
+// lease is not released until done is called.
+func (s safe) lease(ctx context.Context, hash uint64) (ls lease, done func(), err error) {
+	partition := int(s.partitionOf(hash))
+	done = func() { s.leases.done(partition) }
+	if ls, ok := s.leases.use(partition); ok {
+		return ls, done, nil
+	}
+	if _, ok := s.acquireLease(ctx, partition); ok {
+		if ls, ok := s.leases.use(partition); ok {
+			return ls, done, nil
+		}
+	}
+	return lease{}, nil, IncorrectPartitionError{}
+}
+
+// listAll lists the endpoints with metadata if the picker uses it.
+func (s *state) listAll(ctx context.Context, refresh bool) ([]Endpoint, error) {
+	if s.pickWithMetadata != nil {
+		return s.listMetadata(ctx, refresh)
+	}
+	list, err := s.listEndpoints(ctx, refresh)
+	return endpointsOf(list), err
+}
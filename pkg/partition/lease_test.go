@@ -0,0 +1,320 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/tvastar/cluster/pkg/partition"
+	"github.com/tvastar/cluster/pkg/partition/partitiontest"
+)
+
+func TestRedisLeaseStore(t *testing.T) {
+	minir, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer minir.Close()
+
+	ctx := context.Background()
+	store := partition.NewRedisLeaseStore(minir.Addr(), "prefix_")
+	check := func(ok bool, err error) bool {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	fence := func(owner string) uint64 {
+		fence, ok, err := store.Acquire(ctx, 1, owner, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return 0
+		}
+		return fence
+	}
+
+	if fence("a") != 1 || fence("b") != 0 {
+		t.Fatal("lease not exclusive")
+	}
+	if fence("a") != 1 || !check(store.Renew(ctx, 1, "a", time.Minute)) {
+		t.Fatal("lease not extended")
+	}
+	if check(store.Renew(ctx, 1, "b", time.Minute)) {
+		t.Fatal("lease renewed by another owner")
+	}
+
+	if err := store.Release(ctx, 1, "b"); err != nil || fence("b") != 0 {
+		t.Fatal("lease released by another owner", err)
+	}
+	if err := store.Release(ctx, 1, "a"); err != nil || fence("b") != 2 {
+		t.Fatal("lease not released", err)
+	}
+
+	minir.FastForward(2 * time.Minute)
+	if fence("a") != 3 {
+		t.Fatal("lease did not expire")
+	}
+}
+
+func TestLeases(t *testing.T) {
+	minir, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer minir.Close()
+
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	store := partition.NewRedisLeaseStore(minir.Addr(), "prefix_")
+	opts := []partition.Option{
+		partition.WithEndpointRegistry(reg),
+		partition.WithNetwork(nw),
+		partition.WithLeases(store, 8, 300*time.Millisecond),
+	}
+
+	routers := []partition.Router{}
+	for _, addr := range []string{"one", "two"} {
+		r, err := partition.New(ctx, addr, leased(addr), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		routers = append(routers, r)
+	}
+
+	// wait for the leases to move to their owners
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		moved := true
+		for partition := 0; partition < 8; partition++ {
+			owner := pick([]string{"one", "two"}, uint64(partition))
+			if holder, _ := minir.Get(fmt.Sprintf("prefix_lease:%d", partition)); holder != owner {
+				moved = false
+			}
+		}
+		if moved {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("leases not acquired by owners")
+		}
+	}
+
+	for hash := uint64(0); hash < 100; hash++ {
+		owner := pick([]string{"one", "two"}, hash%8)
+		for _, r := range routers {
+			if res, err := r.Run(ctx, hash, nil); err != nil || string(res) != owner {
+				t.Fatal("unexpected", hash, string(res), err)
+			}
+		}
+	}
+}
+
+func pick(list []string, hash uint64) string {
+	return partition.NewPicker()(context.Background(), list, hash)
+}
+
+// leased fails requests which have no fencing token
+type leased string
+
+func (l leased) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+	if fence, ok := partition.FenceFromContext(ctx); !ok || fence == 0 {
+		return nil, fmt.Errorf("no fencing token")
+	}
+	return []byte(l), nil
+}
+
+func TestLeaseReleaseWaitsForRequests(t *testing.T) {
+	minir, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer minir.Close()
+
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	store := partition.NewRedisLeaseStore(minir.Addr(), "prefix_")
+	opts := []partition.Option{partition.WithEndpointRegistry(reg), partition.WithNetwork(nw)}
+
+	// find a partition which moves from one to two
+	moved := 0
+	for pick([]string{"one", "two"}, uint64(moved)) != "two" {
+		moved++
+	}
+	holder := func() string {
+		h, _ := minir.Get(fmt.Sprintf("prefix_lease:%d", moved))
+		return h
+	}
+
+	started, unblock := make(chan struct{}), make(chan struct{})
+	store1 := newSignalingStore(store, moved)
+	leases1 := partition.WithLeases(store1, 64, time.Second)
+	one, err := partition.New(ctx, "one", blocked{started, unblock}, append(opts, leases1)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer one.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := one.Run(ctx, uint64(moved), nil)
+		done <- err
+	}()
+	<-started
+
+	store2 := newSignalingStore(store, moved)
+	leases2 := partition.WithLeases(store2, 64, time.Second)
+	two, err := partition.New(ctx, "two", leased("two"), append(opts, leases2)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer two.Close()
+
+	// two cannot take the lease while the request is running
+	if <-store2.acquired {
+		t.Fatal("lease released while request is running", holder())
+	}
+
+	close(unblock)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	<-store1.released
+	for !<-store2.acquired {
+	}
+	if h := holder(); h != "two" {
+		t.Fatal("lease not moved after request finished", h)
+	}
+}
+
+func TestLeaseContext(t *testing.T) {
+	minir, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer minir.Close()
+
+	ctx := context.Background()
+	store := newSignalingStore(partition.NewRedisLeaseStore(minir.Addr(), "prefix_"), 0)
+	opts := []partition.Option{
+		partition.WithEndpointRegistry(partitiontest.NewRegistry()),
+		partition.WithNetwork(partitiontest.NewNetwork()),
+		partition.WithLeases(store, 1, 600*time.Millisecond),
+	}
+
+	started := make(chan struct{})
+	one, err := partition.New(ctx, "one", canceled(started), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer one.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := one.Run(ctx, 0, nil)
+		done <- err
+	}()
+	<-started
+
+	// the request outlives the initial expiry of the renewed lease
+	for renewals := 0; renewals < 4; renewals++ {
+		if !<-store.renewed {
+			t.Fatal("lease not renewed")
+		}
+	}
+	select {
+	case err := <-done:
+		t.Fatal("request canceled while the lease is held", err)
+	default:
+	}
+
+	// the request is canceled once the lease is taken over
+	minir.Set("prefix_lease:0", "other")
+	if err := <-done; err != context.Canceled {
+		t.Fatal("unexpected", err)
+	}
+}
+
+// canceled runs requests until the context is canceled
+type canceled chan struct{}
+
+func (c canceled) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+	close(c)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// signalingStore reports the results of acquiring and renewing the
+// lease of a partition along with its release
+type signalingStore struct {
+	partition.LeaseStore
+	partition         int
+	acquired, renewed chan bool
+	released          chan struct{}
+}
+
+func newSignalingStore(store partition.LeaseStore, partition int) *signalingStore {
+	return &signalingStore{
+		LeaseStore: store,
+		partition:  partition,
+		acquired:   make(chan bool, 1),
+		renewed:    make(chan bool, 1),
+		released:   make(chan struct{}, 1),
+	}
+}
+
+func (s *signalingStore) Acquire(ctx context.Context, partition int, owner string, ttl time.Duration) (uint64, bool, error) {
+	fence, ok, err := s.LeaseStore.Acquire(ctx, partition, owner, ttl)
+	if partition == s.partition && err == nil {
+		signal(s.acquired, ok)
+	}
+	return fence, ok, err
+}
+
+func (s *signalingStore) Renew(ctx context.Context, partition int, owner string, ttl time.Duration) (bool, error) {
+	ok, err := s.LeaseStore.Renew(ctx, partition, owner, ttl)
+	if partition == s.partition && err == nil {
+		signal(s.renewed, ok)
+	}
+	return ok, err
+}
+
+func (s *signalingStore) Release(ctx context.Context, partition int, owner string) error {
+	err := s.LeaseStore.Release(ctx, partition, owner)
+	if partition == s.partition {
+		select {
+		case s.released <- struct{}{}:
+		default:
+		}
+	}
+	return err
+}
+
+// signal replaces any unread value with the latest one
+func signal(ch chan bool, value bool) {
+	for {
+		select {
+		case ch <- value:
+			return
+		case <-ch:
+		}
+	}
+}
+
+// blocked runs requests until unblocked
+type blocked struct {
+	started, unblock chan struct{}
+}
+
+func (b blocked) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+	close(b.started)
+	<-b.unblock
+	return nil, nil
+}
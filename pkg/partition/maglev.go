@@ -0,0 +1,104 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"hash/fnv"
+)
+
+// NewMaglevPicker returns a picker which uses Maglev hashing.
+//
+// Each pick is a single lookup into a table of the provided size
+// which is rebuilt whenever the set of endpoints changes. The size
+// is rounded up to a prime and should be much larger than the
+// number of endpoints. The default size (if tableSize is zero) is
+// 65537.
+//
+// See https://research.google/pubs/pub44824/
+func NewMaglevPicker(tableSize int) func(ctx context.Context, list []string, hash uint64) string {
+	if tableSize <= 0 {
+		tableSize = 65537
+	}
+	m := &maglev{size: nextPrime(tableSize)}
+	return m.Pick
+}
+
+type maglev struct {
+	size int
+	sortedCache
+}
+
+func (m *maglev) Pick(ctx context.Context, list []string, hash uint64) string {
+	if len(list) == 0 {
+		return ""
+	}
+
+	table := m.local(ctx).get(list, m.populate).([]string)
+	return table[mix(hash)%uint64(m.size)]
+}
+
+// populate builds the lookup table by letting every endpoint claim
+// its next preferred slot in turn.
+func (m *maglev) populate(sorted []string) interface{} {
+	size := uint64(m.size)
+	offsets := make([]uint64, len(sorted))
+	skips := make([]uint64, len(sorted))
+	for kk, ep := range sorted {
+		h1, h2 := fnv.New64a(), fnv.New64()
+		checkWrite(h1.Write([]byte(ep)))
+		checkWrite(h2.Write([]byte(ep)))
+		offsets[kk] = h1.Sum64() % size
+		skips[kk] = h2.Sum64()%(size-1) + 1
+	}
+
+	table := make([]string, size)
+	next := make([]uint64, len(sorted))
+	for filled := 0; filled < m.size; {
+		for kk, ep := range sorted {
+			slot := (offsets[kk] + next[kk]*skips[kk]) % size
+			for table[slot] != "" {
+				next[kk]++
+				slot = (offsets[kk] + next[kk]*skips[kk]) % size
+			}
+			table[slot] = ep
+			next[kk]++
+			if filled++; filled == m.size {
+				break
+			}
+		}
+	}
+	return table
+}
+
+// mix spreads the bits of the hash so that sequential hashes map to
+// unrelated slots.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+func nextPrime(n int) int {
+	for ; ; n++ {
+		if isPrime(n) {
+			return n
+		}
+	}
+}
+
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for kk := 2; kk*kk <= n; kk++ {
+		if n%kk == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,348 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Migrator is optionally implemented by handlers which hold state
+// for the hashes they own.
+//
+// When the local endpoint gains hashes, it pulls the state for them
+// from their previous owners: ExportState is called on the previous
+// owners and ImportState on the new owner for every hash sent.
+// Until the transfer completes, requests for the gained hashes
+// continue to be served by the previous owners. A final catch-up
+// transfer picks up the changes made by those requests, so
+// ImportState may be called again for the same hash and must
+// overwrite the earlier state.
+//
+// A draining endpoint keeps serving transfers until the remaining
+// endpoints have pulled its final state (see Router.Drain).
+type Migrator interface {
+	// ExportState sends the state of every hash for which
+	// include returns true.
+	ExportState(ctx context.Context, include func(hash uint64) bool, send func(hash uint64, data []byte) error) error
+
+	// ImportState stores the state for a hash.
+	ImportState(ctx context.Context, hash uint64, data []byte) error
+}
+
+// StateSource is implemented by the handlers passed to
+// Network.RegisterServer and optionally by the clients returned by
+// Network.DialClient to transfer state between endpoints (see
+// Migrator).
+//
+// Migrate sends the state of the hashes which the target gained
+// from the source when the list of endpoints changed from old to
+// endpoints. Hashes the source did not own with the old list are
+// not sent as the source may hold a stale copy of their state.
+type StateSource interface {
+	Migrate(ctx context.Context, target string, old, endpoints []Endpoint, send func(hash uint64, data []byte) error) error
+}
+
+var errNoMigrate = fmt.Errorf("partition: network does not support migration")
+
+// IsHandoff returns true if the request was forwarded by the new
+// owner of the hash while the state of the hash is being migrated.
+//
+// Handoff requests are not checked for ownership.
+func IsHandoff(ctx context.Context) bool {
+	handoff, _ := ctx.Value(handoffKey{}).(bool)
+	return handoff
+}
+
+// handoffKey is the context key used to expose the handoff flag of
+// the header to handlers.
+type handoffKey struct{}
+
+// migrateTimeout bounds the transfer of state for a single change.
+const migrateTimeout = time.Minute
+
+// migration tracks the transfer of state for a single change.
+type migration struct {
+	change *OwnershipChange
+
+	// handoffs is the number of in-flight requests handed off
+	// to the previous owners
+	handoffs int
+
+	// catchingUp is set during the final transfer: requests for
+	// the gained hashes wait for done instead of being handed off
+	catchingUp bool
+	done       chan struct{}
+}
+
+// migrate pulls the state of the hashes gained in the change from
+// the previous owners. Requests for the gained hashes are handed off
+// to the previous owners during the transfer.
+//
+// The transfer is abandoned when the router is closed.
+//
+// Requests handed off during the transfer may update state which
+// was already sent, so a final catch-up transfer is made once the
+// handed off requests complete. New requests for the gained hashes
+// wait for the catch-up transfer instead of being handed off.
+func (s *state) migrate(m *migration) {
+	defer func() {
+		s.Lock()
+		for kk, pending := range s.migrations {
+			if pending == m {
+				s.migrations = append(s.migrations[:kk:kk], s.migrations[kk+1:]...)
+				break
+			}
+		}
+		s.Unlock()
+		close(m.done)
+	}()
+
+	ctx, cancel := context.WithTimeout(s.migrateCtx, migrateTimeout)
+	defer cancel()
+	s.pullAll(ctx, m.change)
+
+	s.Lock()
+	m.catchingUp = true
+	s.Unlock()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for s.handoffs(m) > 0 {
+		select {
+		case <-ctx.Done():
+			s.migrateError(ctx.Err())
+			return
+		case <-ticker.C:
+		}
+	}
+	s.pullAll(ctx, m.change)
+}
+
+func (s *state) handoffs(m *migration) int {
+	s.Lock()
+	defer s.Unlock()
+	return m.handoffs
+}
+
+// pullAll imports the state from all the previous owners.
+func (s *state) pullAll(ctx context.Context, change *OwnershipChange) {
+	for _, ep := range change.Old {
+		if ep.Addr != s.addr {
+			s.pull(ctx, s.handler.(Migrator), ep.Addr, change)
+		}
+	}
+}
+
+// pull imports the state from the specific endpoint. Failures are
+// reported (see WithMigrationErrorHandler) but the hashes are still
+// served.
+func (s *state) pull(ctx context.Context, m Migrator, addr string, change *OwnershipChange) {
+	c, release, err := s.client(ctx, addr)
+	if err != nil {
+		s.migrateError(fmt.Errorf("partition: migrating from %s: %v", addr, err))
+		return
+	}
+	defer release()
+
+	source, ok := c.(StateSource)
+	if !ok {
+		s.migrateError(errNoMigrate)
+		return
+	}
+	err = source.Migrate(ctx, s.addr, change.Old, change.New, func(hash uint64, data []byte) error {
+		return m.ImportState(ctx, hash, data)
+	})
+	if err != nil {
+		s.migrateError(fmt.Errorf("partition: migrating from %s: %v", addr, err))
+	}
+}
+
+func (s *state) migrateError(err error) {
+	if s.migrateCtx.Err() != nil {
+		// the router was closed
+		return
+	}
+	if s.onMigrateError != nil {
+		s.onMigrateError(err)
+	} else {
+		log.Println("unexpected migration error", err)
+	}
+}
+
+// previousOwner returns the endpoint which should serve the hash
+// while its state is being migrated to the local endpoint along
+// with a function to call once the handed off request completes.
+//
+// During the final catch-up transfer or if the previous owner has
+// left the cluster (it may be draining), this waits for the transfer
+// to complete and returns no endpoint.
+func (s *state) previousOwner(ctx context.Context, hash uint64) (string, func(), error) {
+	for {
+		s.Lock()
+		pending := append([]*migration(nil), s.migrations...)
+		s.Unlock()
+
+		var m *migration
+		prev := ""
+		for _, p := range pending {
+			if len(p.change.Old) > 0 && p.change.Gained(hash) {
+				m, prev = p, p.change.owner(p.change.oldState, p.change.Old, hash)
+				break
+			}
+		}
+		if m == nil {
+			return "", nil, nil
+		}
+
+		s.Lock()
+		left := !isMember(prev, m.change.New) || !isMember(prev, endpointsOf(s.observed))
+		if !m.catchingUp && !left {
+			m.handoffs++
+			s.Unlock()
+			done := func() {
+				s.Lock()
+				defer s.Unlock()
+				m.handoffs--
+			}
+			return prev, done, nil
+		}
+		s.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return "", nil, ctx.Err()
+		case <-m.done:
+		}
+	}
+}
+
+// runOnPrevious hands the request off to the previous owner of the
+// hash if its state is being migrated. It returns false if the
+// request should be handled locally.
+func (s safe) runOnPrevious(ctx context.Context, h header, hash uint64, input []byte) (bool, []byte, error) {
+	if h.handoff {
+		return false, nil, nil
+	}
+
+	prev, done, err := s.previousOwner(ctx, hash)
+	if err != nil || prev == "" {
+		return err != nil, nil, err
+	}
+	defer done()
+
+	output, err := s.runOn(header{handoff: true}.attach(ctx), prev, hash, input)
+	if _, ok := err.(IncorrectPartitionError); ok {
+		// the previous owner is draining
+		return false, nil, nil
+	}
+	return true, output, err
+}
+
+// Migrate implements StateSource.
+func (s safe) Migrate(ctx context.Context, target string, old, endpoints []Endpoint, send func(hash uint64, data []byte) error) error {
+	m, ok := s.handler.(Migrator)
+	if !ok {
+		return nil
+	}
+
+	// exports are tracked as in-flight requests so that Drain
+	// does not stop the server during one. Unlike requests, they
+	// are allowed while draining
+	s.Lock()
+	s.inflight++
+	draining := s.draining
+	s.Unlock()
+	defer s.exit()
+
+	// the state is final once a draining endpoint has no running
+	// requests as it does not accept new ones
+	generation, err := s.settled(ctx, draining)
+	if err != nil {
+		return err
+	}
+
+	change := s.newOwnershipChange(old, endpoints, target)
+	include := func(hash uint64) bool {
+		if len(change.Old) == 0 || !change.ownsNew(hash) {
+			return false
+		}
+		return change.owner(change.oldState, change.Old, hash) == s.addr
+	}
+	if err := m.ExportState(ctx, include, send); err != nil {
+		return err
+	}
+
+	if draining && !isMember(s.addr, endpoints) {
+		s.Lock()
+		if s.exported == nil {
+			s.exported = map[string]uint64{}
+		}
+		s.exported[target] = generation
+		s.Unlock()
+	}
+	return nil
+}
+
+// handle tracks a run of the local handler. The returned function
+// must be called once the handler returns.
+func (s safe) handle() func() {
+	s.Lock()
+	defer s.Unlock()
+	s.running++
+	s.generation++
+	return func() {
+		s.Lock()
+		defer s.Unlock()
+		s.running--
+	}
+}
+
+// settled waits for the running requests to finish if the local
+// endpoint is draining. It returns the generation of the state
+// which changes every time the handler runs.
+func (s safe) settled(ctx context.Context, draining bool) (uint64, error) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		s.Lock()
+		running, generation := s.running, s.generation
+		s.Unlock()
+		if !draining || running == 0 {
+			return generation, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// migrated checks if the state of the local endpoint has been pulled
+// by the peers which are still listed. Only the peers listed before
+// draining are considered as later ones do not pull from the local
+// endpoint.
+func (s *state) migrated(list []string, peers []string) bool {
+	if _, ok := s.handler.(Migrator); !ok {
+		return true
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	listed := endpointsOf(list)
+	for _, addr := range peers {
+		if addr == s.addr || !isMember(addr, listed) {
+			continue
+		}
+		if generation, ok := s.exported[addr]; !ok || generation != s.generation || s.running > 0 {
+			return false
+		}
+	}
+	return true
+}
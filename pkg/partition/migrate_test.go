@@ -0,0 +1,399 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tvastar/cluster/pkg/partition"
+	"github.com/tvastar/cluster/pkg/partition/partitiontest"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	opts := []partition.Option{partition.WithEndpointRegistry(reg), partition.WithNetwork(nw)}
+
+	store1, store2 := &kvStore{data: map[uint64][]byte{}}, &kvStore{data: map[uint64][]byte{}}
+	one, err := partition.New(ctx, "one", store1, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer one.Close()
+
+	for hash := uint64(0); hash < 100; hash++ {
+		if _, err := one.Run(ctx, hash, []byte(fmt.Sprint(hash))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	two, err := partition.New(ctx, "two", store2, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer two.Close()
+
+	// requests are served correctly during and after migration
+	for hash := uint64(0); hash < 100; hash++ {
+		res, err := two.Run(ctx, hash, nil)
+		if err != nil || string(res) != fmt.Sprint(hash) {
+			t.Fatal("unexpected", hash, string(res), err)
+		}
+	}
+
+	gained := 0
+	for hash := uint64(0); hash < 100; hash++ {
+		if partition.NewPicker()(ctx, []string{"one", "two"}, hash) == "two" {
+			gained++
+		}
+	}
+
+	for start := time.Now(); store2.size() != gained; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("state not migrated", store2.size(), gained)
+		}
+	}
+}
+
+func TestMigrateCatchUp(t *testing.T) {
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	opts := []partition.Option{partition.WithEndpointRegistry(reg), partition.WithNetwork(nw)}
+
+	store1 := &heldExport{
+		kvStore:     &kvStore{data: map[uint64][]byte{}},
+		snapshotted: make(chan bool),
+		release:     make(chan bool),
+	}
+	store2 := &kvStore{data: map[uint64][]byte{}}
+	one, err := partition.New(ctx, "one", store1, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer one.Close()
+
+	hash := uint64(0)
+	for ; pick([]string{"one", "two"}, hash) != "two"; hash++ {
+	}
+	if _, err := one.Run(ctx, hash, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	two, err := partition.New(ctx, "two", store2, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer two.Close()
+
+	// this write is handed off after the state was exported
+	<-store1.snapshotted
+	if _, err := two.Run(ctx, hash, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	close(store1.release)
+
+	for start := time.Now(); string(store2.get(hash)) != "new"; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("handed off write was lost", string(store2.get(hash)))
+		}
+	}
+	if res, err := two.Run(ctx, hash, nil); err != nil || string(res) != "new" {
+		t.Fatal("unexpected", string(res), err)
+	}
+}
+
+func TestMigrateOwnerKeepsState(t *testing.T) {
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	opts := []partition.Option{partition.WithEndpointRegistry(reg), partition.WithNetwork(nw)}
+
+	store1 := &kvStore{data: map[uint64][]byte{}}
+	one, err := partition.New(ctx, "one", store1, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer one.Close()
+
+	hash := uint64(0)
+	for pick([]string{"one", "two"}, hash) != "two" || pick([]string{"one", "two", "three"}, hash) != "two" {
+		hash++
+	}
+	if _, err := one.Run(ctx, hash, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	// ownership changes are notified once the migration completes
+	changes := make(chan partition.OwnershipChange, 10)
+	notify := partition.WithOwnershipChange(func(c partition.OwnershipChange) { changes <- c })
+	store2 := &kvStore{data: map[uint64][]byte{}}
+	two, err := partition.New(ctx, "two", store2, append(opts, notify)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer two.Close()
+	next(t, changes)
+
+	// one still holds the old value of the hash
+	if _, err := two.Run(ctx, hash, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if string(store1.get(hash)) != "old" {
+		t.Fatal("unexpected", string(store1.get(hash)))
+	}
+
+	three, err := partition.New(ctx, "three", &kvStore{data: map[uint64][]byte{}}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer three.Close()
+	next(t, changes)
+
+	if res, err := two.Run(ctx, hash, nil); err != nil || string(res) != "new" {
+		t.Fatal("stale state imported", string(res), err)
+	}
+}
+
+func TestMigrateErrors(t *testing.T) {
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	opts := []partition.Option{partition.WithEndpointRegistry(reg), partition.WithNetwork(nw)}
+
+	store1 := &kvStore{data: map[uint64][]byte{}}
+	one, err := partition.New(ctx, "one", store1, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer one.Close()
+	for hash := uint64(0); hash < 100; hash++ {
+		if _, err := one.Run(ctx, hash, []byte(fmt.Sprint(hash))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errs := make(chan error, 10)
+	onError := partition.WithMigrationErrorHandler(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	two, err := partition.New(ctx, "two", failedImport{&kvStore{data: map[uint64][]byte{}}}, append(opts, onError)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer two.Close()
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("unexpected nil error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("migration error not reported")
+	}
+}
+
+func TestMigrateClose(t *testing.T) {
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	opts := []partition.Option{partition.WithEndpointRegistry(reg), partition.WithNetwork(nw)}
+
+	store1 := &blockedExport{kvStore: &kvStore{data: map[uint64][]byte{}}, started: make(chan bool)}
+	one, err := partition.New(ctx, "one", store1, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer one.Close()
+
+	errs := make(chan error, 10)
+	onError := partition.WithMigrationErrorHandler(func(err error) { errs <- err })
+	changes := make(chan partition.OwnershipChange, 10)
+	notify := partition.WithOwnershipChange(func(c partition.OwnershipChange) { changes <- c })
+	two, err := partition.New(ctx, "two", &kvStore{data: map[uint64][]byte{}}, append(opts, onError, notify)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the change is notified once the migration is abandoned
+	<-store1.started
+	if err := two.Close(); err != nil {
+		t.Fatal(err)
+	}
+	next(t, changes)
+
+	select {
+	case err := <-errs:
+		t.Fatal("unexpected error", err)
+	default:
+	}
+}
+
+func TestMigratePreviousOwnerLeft(t *testing.T) {
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	opts := []partition.Option{partition.WithEndpointRegistry(reg), partition.WithNetwork(nw)}
+
+	store1 := &blockedExport{
+		kvStore: &kvStore{data: map[uint64][]byte{}},
+		started: make(chan bool),
+		release: make(chan bool),
+	}
+	one, err := partition.New(ctx, "one", store1, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer one.Close()
+
+	hash := uint64(0)
+	for ; pick([]string{"one", "two"}, hash) != "two"; hash++ {
+	}
+
+	two, err := partition.New(ctx, "two", &kvStore{data: map[uint64][]byte{}}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer two.Close()
+
+	// wait for the departure of one to be observed
+	<-store1.started
+	if err := one.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		if _, ok := two.Connections()["one"]; !ok {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("departure not observed")
+		}
+	}
+
+	// requests wait for the transfer instead of being handed off
+	// to the endpoint which left
+	result := make(chan error, 1)
+	go func() {
+		_, err := two.Run(ctx, hash, []byte("new"))
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		t.Fatal("request did not wait for the transfer", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(store1.release)
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// blockedExport blocks exports until released or the context is
+// done
+type blockedExport struct {
+	*kvStore
+	started, release chan bool
+	once             sync.Once
+}
+
+func (b *blockedExport) ExportState(ctx context.Context, include func(hash uint64) bool, send func(hash uint64, data []byte) error) error {
+	b.once.Do(func() { close(b.started) })
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.release:
+		return b.kvStore.ExportState(ctx, include, send)
+	}
+}
+
+// heldExport holds the first export after taking the snapshot of
+// the state until released
+type heldExport struct {
+	*kvStore
+	snapshotted, release chan bool
+	once                 sync.Once
+}
+
+func (h *heldExport) ExportState(ctx context.Context, include func(hash uint64) bool, send func(hash uint64, data []byte) error) error {
+	h.Lock()
+	snapshot := map[uint64][]byte{}
+	for hash, data := range h.data {
+		snapshot[hash] = data
+	}
+	h.Unlock()
+
+	h.once.Do(func() {
+		close(h.snapshotted)
+		<-h.release
+	})
+	for hash, data := range snapshot {
+		if include(hash) {
+			if err := send(hash, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// failedImport fails every import
+type failedImport struct {
+	*kvStore
+}
+
+func (f failedImport) ImportState(ctx context.Context, hash uint64, data []byte) error {
+	return fmt.Errorf("import failed")
+}
+
+// kvStore stores the input of a request if it is not empty and
+// returns the stored value.
+type kvStore struct {
+	sync.Mutex
+	data map[uint64][]byte
+}
+
+func (k *kvStore) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+	k.Lock()
+	defer k.Unlock()
+	if len(input) > 0 {
+		k.data[hash] = input
+	}
+	return k.data[hash], nil
+}
+
+func (k *kvStore) ExportState(ctx context.Context, include func(hash uint64) bool, send func(hash uint64, data []byte) error) error {
+	k.Lock()
+	defer k.Unlock()
+	for hash, data := range k.data {
+		if include(hash) {
+			if err := send(hash, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (k *kvStore) ImportState(ctx context.Context, hash uint64, data []byte) error {
+	k.Lock()
+	defer k.Unlock()
+	k.data[hash] = data
+	return nil
+}
+
+func (k *kvStore) get(hash uint64) []byte {
+	k.Lock()
+	defer k.Unlock()
+	return k.data[hash]
+}
+
+func (k *kvStore) size() int {
+	k.Lock()
+	defer k.Unlock()
+	return len(k.data)
+}
@@ -24,9 +24,193 @@ type network struct {
 }
 
 func (nw network) DialClient(ctx context.Context, addr string) (RunCloser, error) {
-	return rpc.DialClient(ctx, addr)
+	c, err := rpc.DialClient(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return rpcClient{c}, nil
 }
 
 func (nw network) RegisterServer(ctx context.Context, addr string, handler Runner) (io.Closer, error) {
-	return rpc.RegisterServer(ctx, nw.Server, addr, handler)
+	return rpc.RegisterServer(ctx, nw.Server, addr, rpcServer{handler})
+}
+
+// rpcClient converts Runner calls into RPC requests
+type rpcClient struct {
+	rpc.Client
+}
+
+func (c rpcClient) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+	req := &rpc.RunRequest{
+		Input:     input,
+		Hash:      hash,
+		Broadcast: headerOf(ctx).broadcast,
+		Hedged:    headerOf(ctx).hedged,
+		Handoff:   headerOf(ctx).handoff,
+		Epoch:     headerOf(ctx).epoch,
+		Hops:      uint32(headerOf(ctx).hops),
+	}
+	reply, err := c.Client.Run(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return fromReply(reply)
+}
+
+func (c rpcClient) RunBatch(ctx context.Context, items []Item) ([]Result, error) {
+	req := &rpc.RunBatchRequest{
+		Items: make([]*rpc.RunRequest, len(items)),
+		Epoch: headerOf(ctx).epoch,
+		Hops:  uint32(headerOf(ctx).hops),
+	}
+	for kk, item := range items {
+		req.Items[kk] = &rpc.RunRequest{Input: item.Input, Hash: item.Hash}
+	}
+
+	reply, err := c.Client.RunBatch(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(reply.Replies))
+	for kk, r := range reply.Replies {
+		results[kk].Response, results[kk].Err = fromReply(r)
+	}
+	return results, nil
+}
+
+func (c rpcClient) Migrate(ctx context.Context, target string, old, endpoints []Endpoint, send func(hash uint64, data []byte) error) error {
+	req := &rpc.MigrateRequest{
+		Target:    target,
+		Endpoints: toEndpoints(endpoints),
+		Old:       toEndpoints(old),
+	}
+	stream, err := c.Client.Migrate(ctx, req)
+	if err != nil {
+		return err
+	}
+	for {
+		reply, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = send(reply.Hash, reply.Data); err != nil {
+			return err
+		}
+	}
+}
+
+func fromReply(reply *rpc.RunReply) ([]byte, error) {
+	if reply.IncorrectPartition {
+		e := IncorrectPartitionError{Owner: reply.Owner, Version: reply.Version}
+		if len(reply.Endpoints) > 0 {
+			e.Endpoints = &reply.Endpoints
+		}
+		return nil, e
+	}
+	if reply.Error != "" {
+		return nil, remoteError(reply.Error)
+	}
+	return reply.Response, nil
+}
+
+// rpcServer converts RPC requests into Runner calls
+type rpcServer struct {
+	handler Runner
+}
+
+func (s rpcServer) Run(ctx context.Context, in *rpc.RunRequest) (*rpc.RunReply, error) {
+	ctx = header{
+		broadcast: in.Broadcast,
+		hedged:    in.Hedged,
+		handoff:   in.Handoff,
+		epoch:     in.Epoch,
+		hops:      int(in.Hops),
+	}.attach(ctx)
+	response, err := s.handler.Run(ctx, in.Hash, in.Input)
+	return toReply(response, err), nil
+}
+
+func (s rpcServer) RunBatch(ctx context.Context, in *rpc.RunBatchRequest) (*rpc.RunBatchReply, error) {
+	items := make([]Item, len(in.Items))
+	for kk, item := range in.Items {
+		items[kk] = Item{Hash: item.Hash, Input: item.Input}
+	}
+	ctx = header{epoch: in.Epoch, hops: int(in.Hops)}.attach(ctx)
+
+	results := runItems(ctx, s.handler, items)
+	reply := &rpc.RunBatchReply{Replies: make([]*rpc.RunReply, len(results))}
+	for kk, result := range results {
+		reply.Replies[kk] = toReply(result.Response, result.Err)
+	}
+	return reply, nil
+}
+
+func (s rpcServer) Migrate(in *rpc.MigrateRequest, stream rpc.Runner_MigrateServer) error {
+	source, ok := s.handler.(StateSource)
+	if !ok {
+		return errNoMigrate
+	}
+
+	old, endpoints := fromEndpoints(in.Old), fromEndpoints(in.Endpoints)
+	return source.Migrate(stream.Context(), in.Target, old, endpoints, func(hash uint64, data []byte) error {
+		return stream.Send(&rpc.MigrateReply{Hash: hash, Data: data})
+	})
+}
+
+func toReply(response []byte, err error) *rpc.RunReply {
+	if e, ok := err.(IncorrectPartitionError); ok {
+		reply := &rpc.RunReply{
+			Response:           response,
+			Error:              err.Error(),
+			IncorrectPartition: true,
+			Version:            e.Version,
+			Owner:              e.Owner,
+		}
+		if e.Endpoints != nil {
+			reply.Endpoints = *e.Endpoints
+		}
+		return reply
+	}
+	if err != nil {
+		return &rpc.RunReply{Response: response, Error: err.Error()}
+	}
+	return &rpc.RunReply{Response: response}
+}
+
+func toEndpoints(eps []Endpoint) []*rpc.Endpoint {
+	result := make([]*rpc.Endpoint, len(eps))
+	for kk, ep := range eps {
+		result[kk] = &rpc.Endpoint{
+			Addr:    ep.Addr,
+			Zone:    ep.Zone,
+			Version: ep.Version,
+			Weight:  ep.Weight,
+			Labels:  ep.Labels,
+		}
+	}
+	return result
+}
+
+func fromEndpoints(eps []*rpc.Endpoint) []Endpoint {
+	result := make([]Endpoint, len(eps))
+	for kk, ep := range eps {
+		result[kk] = Endpoint{
+			Addr:    ep.Addr,
+			Zone:    ep.Zone,
+			Version: ep.Version,
+			Weight:  ep.Weight,
+			Labels:  ep.Labels,
+		}
+	}
+	return result
+}
+
+type remoteError string
+
+func (e remoteError) Error() string {
+	return string(e)
 }
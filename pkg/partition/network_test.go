@@ -0,0 +1,71 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestRPCMigrate(t *testing.T) {
+	ctx := context.Background()
+	srv := grpc.NewServer()
+	defer srv.Stop()
+
+	source := &stubSource{stubRunner: stubRunner(nil)}
+	nw := NewRPCNetwork(srv)
+	if _, err := nw.RegisterServer(ctx, "", source); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+
+	c, err := nw.DialClient(ctx, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	old := []Endpoint{{Addr: "a", Zone: "us-west1-a", Version: "v1", Weight: 2.5, Labels: map[string]string{"canary": "true"}}}
+	endpoints := append(old, Endpoint{Addr: "b"})
+	received := map[uint64]string{}
+	err = c.(StateSource).Migrate(ctx, "b", old, endpoints, func(hash uint64, data []byte) error {
+		received[hash] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if source.target != "b" || !reflect.DeepEqual(source.old, old) || !reflect.DeepEqual(source.endpoints, endpoints) {
+		t.Fatal("unexpected request", source.target, source.old, source.endpoints)
+	}
+	if expected := map[uint64]string{1: "one", 2: "two"}; !reflect.DeepEqual(received, expected) {
+		t.Fatal("unexpected state", received)
+	}
+}
+
+// stubSource records the migration request and sends a fixed state
+type stubSource struct {
+	stubRunner
+	target         string
+	old, endpoints []Endpoint
+}
+
+func (s *stubSource) Migrate(ctx context.Context, target string, old, endpoints []Endpoint, send func(hash uint64, data []byte) error) error {
+	s.target, s.old, s.endpoints = target, old, endpoints
+	if err := send(1, []byte("one")); err != nil {
+		return err
+	}
+	return send(2, []byte("two"))
+}
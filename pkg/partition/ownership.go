@@ -0,0 +1,157 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import "context"
+
+// OwnershipChange describes a change in the membership of the
+// cluster as seen by the router.
+//
+// Pickers do not generally map hashes to contiguous ranges, so the
+// change is expressed as a way to test individual hashes: Gained
+// and Lost report whether the local endpoint gained or lost the
+// hash as a result of the change.
+type OwnershipChange struct {
+	Old, New []Endpoint
+
+	self        string
+	pick        func(ctx context.Context, list []Endpoint, hash uint64) string
+	partitionOf func(hash uint64) uint64
+
+	// the old and new lists each use their own picker state so
+	// that neither evicts the other or the routing list
+	oldState, newState *pickerState
+
+	// migration tracks the transfer of state for the change (see
+	// state.changed)
+	migration *migration
+}
+
+// newOwnershipChange creates a change using the configured picker.
+func (s *state) newOwnershipChange(old, eps []Endpoint, self string) *OwnershipChange {
+	return &OwnershipChange{
+		Old:         old,
+		New:         eps,
+		self:        self,
+		pick:        s.picker(),
+		partitionOf: s.partitionOf,
+		oldState:    &pickerState{},
+		newState:    &pickerState{},
+	}
+}
+
+// Gained returns true if the local endpoint owns the hash with the
+// new membership but did not with the old one.
+func (c OwnershipChange) Gained(hash uint64) bool {
+	return !c.ownsOld(hash) && c.ownsNew(hash)
+}
+
+// Lost returns true if the local endpoint owned the hash with the
+// old membership but does not with the new one.
+func (c OwnershipChange) Lost(hash uint64) bool {
+	return c.ownsOld(hash) && !c.ownsNew(hash)
+}
+
+func (c OwnershipChange) ownsOld(hash uint64) bool {
+	return len(c.Old) > 0 && c.owner(c.oldState, c.Old, hash) == c.self
+}
+
+func (c OwnershipChange) ownsNew(hash uint64) bool {
+	return len(c.New) > 0 && c.owner(c.newState, c.New, hash) == c.self
+}
+
+func (c OwnershipChange) owner(ps *pickerState, list []Endpoint, hash uint64) string {
+	ctx := withPickerState(context.Background(), ps)
+	return c.pick(ctx, list, c.partitionOf(hash))
+}
+
+// changed queues an ownership change notification. This must be
+// called with the lock held.
+func (s *state) changed(old, list []string, oldEps, eps []Endpoint) {
+	_, migrates := s.handler.(Migrator)
+	if s.handler == nil || s.onOwnershipChange == nil && !migrates {
+		return
+	}
+
+	if eps == nil {
+		eps = endpointsOf(list)
+	}
+	if oldEps == nil && old != nil {
+		oldEps = endpointsOf(old)
+	}
+
+	change := s.newOwnershipChange(oldEps, eps, s.addr)
+	if migrates {
+		from := change
+		if old == nil {
+			// the first change has no old endpoints but the
+			// other endpoints may hold the state of the hashes
+			from = s.newOwnershipChange(others(eps, s.addr), eps, s.addr)
+		}
+		change.migration = &migration{change: from, done: make(chan struct{})}
+		s.migrations = append(s.migrations, change.migration)
+	}
+	s.changes = append(s.changes, change)
+	if !s.notifying {
+		s.notifying = true
+		go s.notifyChanges()
+	}
+}
+
+// picker returns the configured picker as one which works with
+// endpoint metadata.
+func (s *state) picker() func(ctx context.Context, list []Endpoint, hash uint64) string {
+	if s.pickWithMetadata != nil {
+		return s.pickWithMetadata
+	}
+	return func(ctx context.Context, list []Endpoint, hash uint64) string {
+		return s.pickEndpoint(ctx, addrsOf(list), hash)
+	}
+}
+
+// notifyChanges delivers the queued changes in order.
+func (s *state) notifyChanges() {
+	_, migrates := s.handler.(Migrator)
+	for {
+		s.Lock()
+		changes := s.changes
+		s.changes = nil
+		if len(changes) == 0 {
+			s.notifying = false
+		}
+		s.Unlock()
+
+		if len(changes) == 0 {
+			return
+		}
+		for _, change := range changes {
+			if migrates {
+				s.migrate(change.migration)
+			}
+			if s.onOwnershipChange != nil {
+				s.onOwnershipChange(*change)
+			}
+		}
+	}
+}
+
+func isMember(addr string, list []Endpoint) bool {
+	for _, ep := range list {
+		if ep.Addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func others(list []Endpoint, addr string) []Endpoint {
+	result := []Endpoint{}
+	for _, ep := range list {
+		if ep.Addr != addr {
+			result = append(result, ep)
+		}
+	}
+	return result
+}
@@ -0,0 +1,71 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tvastar/cluster/pkg/partition"
+	"github.com/tvastar/cluster/pkg/partition/partitiontest"
+)
+
+func TestOwnershipChange(t *testing.T) {
+	ctx := context.Background()
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	opts := []partition.Option{partition.WithEndpointRegistry(reg), partition.WithNetwork(nw)}
+
+	changes := make(chan partition.OwnershipChange, 10)
+	notify := partition.WithOwnershipChange(func(c partition.OwnershipChange) { changes <- c })
+	one, err := partition.New(ctx, "one", owner("one"), append(opts, notify)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer one.Close()
+
+	if c := next(t, changes); len(c.Old) != 0 || len(c.New) != 1 || !c.Gained(5) || c.Lost(5) {
+		t.Fatal("unexpected initial change", c)
+	}
+
+	two, err := partition.New(ctx, "two", owner("two"), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := next(t, changes)
+	lost := 0
+	for hash := uint64(0); hash < 1000; hash++ {
+		owned := partition.NewPicker()(ctx, []string{"one", "two"}, hash) == "one"
+		if c.Gained(hash) || c.Lost(hash) == owned {
+			t.Fatal("unexpected change", hash, c.Gained(hash), c.Lost(hash))
+		}
+		if c.Lost(hash) {
+			lost++
+		}
+	}
+	if lost == 0 {
+		t.Fatal("no hashes lost")
+	}
+
+	two.Close()
+	c = next(t, changes)
+	for hash := uint64(0); hash < 1000; hash++ {
+		owned := partition.NewPicker()(ctx, []string{"one", "two"}, hash) == "one"
+		if c.Lost(hash) || c.Gained(hash) == owned {
+			t.Fatal("unexpected change", hash, c.Gained(hash), c.Lost(hash))
+		}
+	}
+}
+
+func next(t *testing.T, changes chan partition.OwnershipChange) partition.OwnershipChange {
+	select {
+	case c := <-changes:
+		return c
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for ownership change")
+	}
+	return partition.OwnershipChange{}
+}
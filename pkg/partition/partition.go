@@ -56,17 +56,38 @@ package partition
 import (
 	"context"
 	"io"
+	"time"
 )
 
 // EndpointRegistry manages the live list of endpoints in a cluster.
 //
 // The partition package does not cache the results so any requirement
-// to cache this should be
+// to cache this should be handled by the registry (see
+// NewCachingRegistry).
+//
+// ListEndpoints is called with refresh set when the caller suspects
+// its view is stale and registries which cache the list should
+// reload it in that case.
 type EndpointRegistry interface {
 	RegisterEndpoint(ctx context.Context, addr string) (io.Closer, error)
 	ListEndpoints(ctx context.Context, refresh bool) ([]string, error)
 }
 
+// EndpointWatcher is optionally implemented by an EndpointRegistry
+// which can push membership changes instead of being polled.
+//
+// WatchEndpoints returns a channel which receives the current list
+// of endpoints right away and then again every time it changes. The
+// channel is closed once the context is done.
+//
+// The router automatically subscribes to registries which implement
+// this interface, falling back to ListEndpoints if WatchEndpoints
+// fails, does not deliver the first list in time or the channel is
+// closed early.
+type EndpointWatcher interface {
+	WatchEndpoints(ctx context.Context) (<-chan []string, error)
+}
+
 // Network implements the communication network between servers in the clsuter.
 type Network interface {
 	DialClient(ctx context.Context, addr string) (RunCloser, error)
@@ -84,11 +105,64 @@ type RunCloser interface {
 	io.Closer
 }
 
+// Router routes requests to the endpoints which own them.
+type Router interface {
+	RunCloser
+
+	// RunReplicated runs the request on the replicas of the hash
+	// (see WithReplicas), combining the responses as specified by
+	// the read policy (see WithReadPolicy).
+	RunReplicated(ctx context.Context, hash uint64, input []byte) ([]byte, error)
+
+	// Broadcast runs the request on every endpoint in the
+	// cluster, returning the results keyed by the address of the
+	// endpoint.
+	//
+	// The error is the first error returned by any endpoint
+	// unless partial results are allowed (see
+	// WithPartialResults).
+	Broadcast(ctx context.Context, input []byte) (map[string]Result, error)
+
+	// RunBatch runs many requests at once, sending a single batch
+	// to each owner. The results are in the same order as the
+	// items.
+	//
+	// The error is only used for failures which affect the whole
+	// batch: individual failures are reported in the results.
+	RunBatch(ctx context.Context, items []Item) ([]Result, error)
+
+	// Connections returns the state of the connections to the
+	// other endpoints. Connections to endpoints which leave the
+	// cluster are closed automatically.
+	Connections() map[string]ConnState
+
+	// Drain removes the local endpoint from the registry and
+	// rejects new requests with IncorrectPartitionError (so that
+	// they are retried on other endpoints). It waits for
+	// in-flight requests to finish and for the registry to stop
+	// listing the local endpoint (or for the context to be done)
+	// before stopping the server. Registries which cannot remove
+	// endpoints, such as NewStaticRegistry, are not waited on.
+	// The wait is bounded to a minute if the context has no
+	// deadline. The server is stopped even if the wait does not
+	// complete, in which case the context error is returned.
+	//
+	// If the handler implements Migrator, Drain also waits for
+	// the remaining endpoints to pull the state of the local
+	// endpoint.
+	//
+	// Close must still be called after Drain.
+	Drain(ctx context.Context) error
+}
+
 var defaultConfig = config{
-	Network: NewRPCNetwork(nil),
+	Network:           NewRPCNetwork(nil),
+	retry:             defaultRetryPolicy,
+	replicas:          1,
+	localShortCircuit: true,
 }
 
-// New returns a RunCloser which targets requests to
+// New returns a Router which targets requests to
 // specific endpoints based on the hash provided to the request.
 //
 // This automatically adds the provided address to the cluster. The
@@ -97,9 +171,10 @@ var defaultConfig = config{
 //
 // Defaults are used for Picker and Network but EndpointRegistry must
 // be specified -- no defaults are used for it.
-func New(ctx context.Context, addr string, handler Runner, opts ...Option) (RunCloser, error) {
+func New(ctx context.Context, addr string, handler Runner, opts ...Option) (Router, error) {
 	s := &state{config: defaultConfig, addr: addr, handler: handler}
 	s.config.pickEndpoint = NewPicker()
+	s.config.rank = NewRanker()
 	for _, opt := range opts {
 		opt(&s.config)
 	}
@@ -110,7 +185,30 @@ type config struct {
 	EndpointRegistry
 	Network
 
-	pickEndpoint func(ctx context.Context, list []string, hash uint64) string
+	pickEndpoint     func(ctx context.Context, list []string, hash uint64) string
+	pickWithMetadata func(ctx context.Context, list []Endpoint, hash uint64) string
+	metadata         Endpoint
+	retry            RetryPolicy
+
+	replicas   int
+	rank       func(ctx context.Context, list []string, hash uint64, n int) []string
+	readPolicy ReadPolicy
+
+	hedgeAfter      time.Duration
+	hedgePercentile float64
+
+	parallelism    int
+	partialResults bool
+
+	localShortCircuit bool
+	onOwnershipChange func(change OwnershipChange)
+	onMigrateError    func(err error)
+
+	leaseStore      LeaseStore
+	leasePartitions int
+	leaseTTL        time.Duration
+
+	maxHops int
 }
 
 // Option configures the partitioning algorithm.
@@ -122,6 +220,8 @@ type Option func(c *config)
 // There is no defaualt endpoint registry.
 //
 // RedisRegistry implements a Redis-based endpoint registry.
+// NewStaticRegistry and NewFileRegistry can be used when the list of
+// endpoints is managed externally.
 func WithEndpointRegistry(r EndpointRegistry) Option {
 	return func(c *config) {
 		c.EndpointRegistry = r
@@ -131,10 +231,50 @@ func WithEndpointRegistry(r EndpointRegistry) Option {
 // WithPicker specifies how the pick endpoints based on the hash.
 //
 // The default algorithm is to use the highest random weight
-// algorithm (via NewPicker())
+// algorithm (via NewPicker()).  NewHashRing, NewBoundedLoadPicker,
+// NewJumpHashPicker and NewMaglevPicker provide alternate
+// algorithms.
 func WithPicker(picker func(ctx context.Context, list []string, hash uint64) string) Option {
 	return func(c *config) {
-		c.pickEndpoint = picker
+		c.pickEndpoint, c.pickWithMetadata = picker, nil
+	}
+}
+
+// WithEndpointPicker specifies a picker which uses the endpoint
+// metadata (such as the weight) to pick endpoints based on the hash.
+//
+// The metadata is fetched from the registry if it implements
+// MetadataRegistry.  NewWeightedPicker and NewWeightedHashRing
+// provide weighted algorithms. NewZonePicker prefers endpoints in
+// the same zone.
+func WithEndpointPicker(picker func(ctx context.Context, list []Endpoint, hash uint64) string) Option {
+	return func(c *config) {
+		c.pickWithMetadata = picker
+	}
+}
+
+// WithEndpointMetadata specifies the metadata of the local
+// endpoint. This is registered with the endpoint registry which must
+// implement MetadataRegistry. The address is ignored: the address
+// provided to New is used instead.
+//
+// The metadata is only used by pickers configured via
+// WithEndpointPicker.
+func WithEndpointMetadata(ep Endpoint) Option {
+	return func(c *config) {
+		c.metadata = ep
+	}
+}
+
+// WithWeight specifies the weight of the local endpoint. This is
+// registered with the endpoint registry which must implement
+// MetadataRegistry.
+//
+// Weights are only used by pickers configured via
+// WithEndpointPicker.
+func WithWeight(weight float64) Option {
+	return func(c *config) {
+		c.metadata.Weight = weight
 	}
 }
 
@@ -147,3 +287,180 @@ func WithNetwork(nw Network) Option {
 		c.Network = nw
 	}
 }
+
+// WithRetryPolicy specifies how requests that end up on the wrong
+// partition (see IncorrectPartitionError) are retried.
+//
+// The default policy makes up to 3 attempts, starting with a 10ms
+// backoff.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *config) {
+		c.retry = policy
+	}
+}
+
+// WithReplicas specifies the number of endpoints which own each
+// hash. This is used by RunReplicated: Run only uses the first of
+// them.
+//
+// The replicas are chosen by the ranker (see WithRanker). Each of
+// the replicas accepts requests for the hash.
+func WithReplicas(n int) Option {
+	return func(c *config) {
+		if n < 1 {
+			n = 1
+		}
+		c.replicas = n
+	}
+}
+
+// WithRanker specifies how to order endpoints for a hash when
+// picking replicas.
+//
+// The default ranker (via NewRanker()) matches the default
+// picker. NewHashRingRanker should be used along with NewHashRing.
+func WithRanker(ranker func(ctx context.Context, list []string, hash uint64, n int) []string) Option {
+	return func(c *config) {
+		c.rank = ranker
+	}
+}
+
+// WithReadPolicy specifies how RunReplicated combines the responses
+// of the replicas.
+//
+// The default policy is FirstSuccess.
+func WithReadPolicy(policy ReadPolicy) Option {
+	return func(c *config) {
+		c.readPolicy = policy
+	}
+}
+
+// WithHedging sends the request to the next ranked endpoint (see
+// WithRanker) if the owner has not responded within the delay. The
+// first successful response is used and the other request is
+// canceled.
+//
+// Handlers must be idempotent as the request may run on both
+// endpoints. Hedged requests are marked as such and the next ranked
+// endpoint accepts them even if it is not configured for hedging.
+func WithHedging(delay time.Duration) Option {
+	return func(c *config) {
+		c.hedgeAfter = delay
+	}
+}
+
+// WithHedgingPercentile is like WithHedging but the delay is the
+// specified percentile (such as 95) of the recent latencies of
+// requests.
+//
+// The delay specified via WithHedging is used until enough requests
+// have been made.
+func WithHedgingPercentile(percentile float64) Option {
+	return func(c *config) {
+		c.hedgePercentile = percentile
+	}
+}
+
+// WithParallelism limits the number of concurrent requests made by
+// Broadcast.
+//
+// The default is 16.
+func WithParallelism(n int) Option {
+	return func(c *config) {
+		c.parallelism = n
+	}
+}
+
+// WithPartialResults specifies whether Broadcast succeeds when only
+// some of the endpoints succeed. At least one endpoint must succeed
+// in either case.
+//
+// By default, Broadcast fails if any of the endpoints fail.
+func WithPartialResults(allow bool) Option {
+	return func(c *config) {
+		c.partialResults = allow
+	}
+}
+
+// WithLocalShortCircuit specifies whether requests for hashes owned
+// by the local endpoint are handled directly instead of via the
+// network. The ownership of the hash is checked in either case.
+//
+// This is enabled by default. Disabling it is mainly useful to
+// test the network path.
+func WithLocalShortCircuit(enabled bool) Option {
+	return func(c *config) {
+		c.localShortCircuit = enabled
+	}
+}
+
+// WithOwnershipChange registers a callback which is called whenever
+// the router observes a change in the membership of the cluster.
+// This can be used to warm up caches for hashes gained by the local
+// endpoint and to flush state for hashes it lost.
+//
+// The first change has no old endpoints. Changes are delivered in
+// order on a separate goroutine.
+func WithOwnershipChange(fn func(change OwnershipChange)) Option {
+	return func(c *config) {
+		c.onOwnershipChange = fn
+	}
+}
+
+// WithMigrationErrorHandler specifies a callback for errors
+// encountered while pulling the state of gained hashes from their
+// previous owners (see Migrator). The hashes are served regardless
+// but their state may be incomplete.
+//
+// The default is to log the errors.
+func WithMigrationErrorHandler(fn func(err error)) Option {
+	return func(c *config) {
+		c.onMigrateError = fn
+	}
+}
+
+// WithLeases enables exclusive ownership: hashes are grouped into
+// the specified number of virtual partitions and the owner of a
+// partition must hold a lease on it from the store before running
+// any request for it. The handler is called with a context which
+// is canceled once the lease is lost (taken over or not renewed
+// before it expires) and carries the fencing token of the lease
+// (see FenceFromContext).
+//
+// Leases are renewed every third of the TTL and released once the
+// partition is owned by another endpoint (or the router is closed)
+// and the requests running under them finish. Only broadcasts run
+// without a lease. Requests may fail with IncorrectPartitionError
+// (and be retried) while ownership moves. The default TTL is 10
+// seconds.
+//
+// NewRedisLeaseStore implements a Redis-based lease store.
+func WithLeases(store LeaseStore, partitions int, ttl time.Duration) Option {
+	return func(c *config) {
+		if partitions < 1 {
+			partitions = 1
+		}
+		if ttl <= 0 {
+			ttl = 10 * time.Second
+		}
+		c.leaseStore, c.leasePartitions, c.leaseTTL = store, partitions, ttl
+	}
+}
+
+// WithForwarding specifies that misrouted requests are forwarded to
+// the owner known to the receiving endpoint instead of being
+// rejected with an IncorrectPartitionError.
+//
+// This helps during rolling restarts when callers often have a
+// stale view of the cluster. Requests are forwarded at most maxHops
+// times to avoid loops when endpoints disagree about the owner.
+// Zero disables forwarding, which is the default.
+//
+// Requests are only forwarded to endpoints known to the receiving
+// endpoint. Misrouted items of a batch are forwarded with a single
+// batch per owner.
+func WithForwarding(maxHops int) Option {
+	return func(c *config) {
+		c.maxHops = maxHops
+	}
+}
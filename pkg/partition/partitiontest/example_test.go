@@ -0,0 +1,72 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partitiontest_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tvastar/cluster/pkg/partition"
+	"github.com/tvastar/cluster/pkg/partition/partitiontest"
+)
+
+func Example() {
+	reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+	opts := []partition.Option{
+		partition.WithEndpointRegistry(reg),
+		partition.WithNetwork(nw),
+	}
+
+	// part1 reports when it sees part2
+	joined := make(chan struct{}, 1)
+	watch := partition.WithOwnershipChange(func(change partition.OwnershipChange) {
+		if len(change.New) == 2 {
+			joined <- struct{}{}
+		}
+	})
+
+	ctx := context.Background()
+	part1, err := partition.New(ctx, "one", handler(1), append(opts, watch)...)
+	if err != nil {
+		panic(err)
+	}
+	defer part1.Close()
+
+	part2, err := partition.New(ctx, "two", handler(2), opts...)
+	if err != nil {
+		panic(err)
+	}
+	defer part2.Close()
+
+	<-joined
+
+	for _, hash := range []uint64{555, 22222} {
+		if _, err := part1.Run(ctx, hash, []byte("hello")); err != nil {
+			panic(err)
+		}
+		if _, err := part2.Run(ctx, hash, []byte("hello")); err != nil {
+			panic(err)
+		}
+	}
+
+	// isolated endpoints are unreachable
+	nw.Isolate("one", "two")
+	_, err = part1.Run(ctx, 555, []byte("hello"))
+	fmt.Println(err)
+
+	// Output:
+	// [2] Run(555, hello)
+	// [2] Run(555, hello)
+	// [1] Run(22222, hello)
+	// [1] Run(22222, hello)
+	// partitiontest: endpoint unreachable
+}
+
+type handler int
+
+func (h handler) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+	fmt.Printf("[%d] Run(%d, %s)\n", int(h), hash, string(input))
+	return input, nil
+}
@@ -0,0 +1,270 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partitiontest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/tvastar/cluster/pkg/partition"
+)
+
+// ErrUnreachable is returned for requests to servers which are not
+// registered, have been closed, have been isolated or are split off
+// from the caller.
+var ErrUnreachable = errors.New("partitiontest: endpoint unreachable")
+
+// ErrDropped is returned for requests which were dropped by the
+// network (see SetDropRate).
+var ErrDropped = errors.New("partitiontest: request dropped")
+
+// Network is an in-process partition.Network.
+//
+// Requests are delivered to servers via channels. The context of
+// the request is passed through as is to the handler.
+//
+// The network does not know which endpoint a request comes from
+// unless the client was dialed via the view returned by From. Only
+// such requests are subject to Split.
+type Network struct {
+	sync.Mutex
+	servers  map[string]*server
+	latency  map[string]time.Duration
+	drops    map[string]float64
+	isolated map[string]bool
+	groups   map[string]int
+}
+
+// NewNetwork creates a new network with no servers.
+func NewNetwork() *Network {
+	return &Network{
+		servers:  map[string]*server{},
+		latency:  map[string]time.Duration{},
+		drops:    map[string]float64{},
+		isolated: map[string]bool{},
+		groups:   map[string]int{},
+	}
+}
+
+// SetLatency delays every request to the address by the provided
+// duration.
+func (n *Network) SetLatency(addr string, d time.Duration) {
+	n.Lock()
+	defer n.Unlock()
+	n.latency[addr] = d
+}
+
+// SetDropRate fails the specified fraction of requests to the
+// address with ErrDropped.
+func (n *Network) SetDropRate(addr string, rate float64) {
+	n.Lock()
+	defer n.Unlock()
+	n.drops[addr] = rate
+}
+
+// Isolate partitions the addresses off the network: requests to
+// them fail with ErrUnreachable until Heal is called.
+func (n *Network) Isolate(addrs ...string) {
+	n.Lock()
+	defer n.Unlock()
+	for _, addr := range addrs {
+		n.isolated[addr] = true
+	}
+}
+
+// Heal undoes the effect of Isolate.
+func (n *Network) Heal(addrs ...string) {
+	n.Lock()
+	defer n.Unlock()
+	for _, addr := range addrs {
+		delete(n.isolated, addr)
+	}
+}
+
+// Split partitions the network into the provided groups of
+// addresses: requests between endpoints of different groups fail
+// with ErrUnreachable until Rejoin is called. Endpoints which are
+// not part of any group are not affected.
+//
+// Only requests from clients dialed via From are split as the
+// source of other requests is not known.
+func (n *Network) Split(groups ...[]string) {
+	n.Lock()
+	defer n.Unlock()
+	n.groups = map[string]int{}
+	for kk, group := range groups {
+		for _, addr := range group {
+			n.groups[addr] = kk + 1
+		}
+	}
+}
+
+// Rejoin undoes the effect of Split.
+func (n *Network) Rejoin() {
+	n.Lock()
+	defer n.Unlock()
+	n.groups = map[string]int{}
+}
+
+// From returns a view of the network for the endpoint with the
+// provided address. Requests made via clients dialed from the view
+// are subject to Split.
+//
+// Use this with partition.WithNetwork for each router:
+//
+//	partition.New(ctx, "one", h, partition.WithNetwork(nw.From("one")))
+func (n *Network) From(addr string) partition.Network {
+	return view{n, addr}
+}
+
+// DialClient implements partition.Network. Dialing never fails: any
+// errors are reported when the client is used.
+func (n *Network) DialClient(ctx context.Context, addr string) (partition.RunCloser, error) {
+	return client{n, "", addr}, nil
+}
+
+// RegisterServer implements partition.Network.
+func (n *Network) RegisterServer(ctx context.Context, addr string, handler partition.Runner) (io.Closer, error) {
+	n.Lock()
+	defer n.Unlock()
+
+	if _, ok := n.servers[addr]; ok {
+		return nil, errors.New("partitiontest: address already in use " + addr)
+	}
+
+	s := &server{handler: handler, requests: make(chan request), done: make(chan struct{})}
+	n.servers[addr] = s
+	go s.serve(handler)
+
+	return closer(func() {
+		n.Lock()
+		defer n.Unlock()
+		if n.servers[addr] == s {
+			delete(n.servers, addr)
+			close(s.done)
+		}
+	}), nil
+}
+
+// route applies the network conditions for the address and returns
+// the server to deliver the request to.
+func (n *Network) route(ctx context.Context, from, addr string) (*server, error) {
+	n.Lock()
+	s, isolated := n.servers[addr], n.isolated[addr]
+	latency, drop := n.latency[addr], n.drops[addr]
+	split := n.groups[from] != 0 && n.groups[addr] != 0 && n.groups[from] != n.groups[addr]
+	n.Unlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	switch {
+	case s == nil || isolated || split:
+		return nil, ErrUnreachable
+	case drop > 0 && rand.Float64() < drop:
+		return nil, ErrDropped
+	}
+	return s, nil
+}
+
+// view is the network as seen from a specific endpoint
+type view struct {
+	*Network
+	from string
+}
+
+func (v view) DialClient(ctx context.Context, addr string) (partition.RunCloser, error) {
+	return client{v.Network, v.from, addr}, nil
+}
+
+type client struct {
+	*Network
+	from, addr string
+}
+
+func (c client) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+	s, err := c.route(ctx, c.from, c.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	req := request{ctx, hash, input, make(chan response, 1)}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.done:
+		return nil, ErrUnreachable
+	case s.requests <- req:
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-req.reply:
+		return res.output, res.err
+	}
+}
+
+// Migrate implements partition.StateSource by calling the handler
+// of the server directly.
+func (c client) Migrate(ctx context.Context, target string, old, endpoints []partition.Endpoint, send func(hash uint64, data []byte) error) error {
+	s, err := c.route(ctx, c.from, c.addr)
+	if err != nil {
+		return err
+	}
+
+	source, ok := s.handler.(partition.StateSource)
+	if !ok {
+		return errors.New("partitiontest: handler does not support migration")
+	}
+	return source.Migrate(ctx, target, old, endpoints, send)
+}
+
+func (c client) Close() error {
+	return nil
+}
+
+type request struct {
+	ctx   context.Context
+	hash  uint64
+	input []byte
+	reply chan response
+}
+
+type response struct {
+	output []byte
+	err    error
+}
+
+type server struct {
+	handler  partition.Runner
+	requests chan request
+	done     chan struct{}
+}
+
+func (s *server) serve(handler partition.Runner) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case req := <-s.requests:
+			go func() {
+				output, err := handler.Run(req.ctx, req.hash, req.input)
+				req.reply <- response{output, err}
+			}()
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partitiontest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tvastar/cluster/pkg/partition/partitiontest"
+)
+
+func TestNetwork(t *testing.T) {
+	nw := partitiontest.NewNetwork()
+	ctx := context.Background()
+
+	closer, err := nw.RegisterServer(ctx, "boo", handler(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := nw.RegisterServer(ctx, "boo", handler(2)); err == nil {
+		t.Fatal("duplicate registration succeeded")
+	}
+
+	c, err := nw.DialClient(ctx, "boo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nw.SetDropRate("boo", 1)
+	if _, err := c.Run(ctx, 0, nil); err != partitiontest.ErrDropped {
+		t.Fatal("unexpected", err)
+	}
+	nw.SetDropRate("boo", 0)
+
+	nw.SetLatency("boo", time.Hour)
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Run(timeout, 0, nil); err != context.DeadlineExceeded {
+		t.Fatal("unexpected", err)
+	}
+	nw.SetLatency("boo", 0)
+
+	nw.Isolate("boo")
+	if _, err := c.Run(ctx, 0, nil); err != partitiontest.ErrUnreachable {
+		t.Fatal("unexpected", err)
+	}
+	nw.Heal("boo")
+
+	if res, err := c.Run(ctx, 0, []byte("ok")); err != nil || string(res) != "ok" {
+		t.Fatal("unexpected", string(res), err)
+	}
+
+	nw.Split([]string{"a"}, []string{"boo"})
+	if _, err := c.Run(ctx, 0, nil); err != nil {
+		t.Fatal("split affected a client without a source", err)
+	}
+	a, err := nw.From("a").DialClient(ctx, "boo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Run(ctx, 0, nil); err != partitiontest.ErrUnreachable {
+		t.Fatal("unexpected", err)
+	}
+	b, err := nw.From("b").DialClient(ctx, "boo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Run(ctx, 0, nil); err != nil {
+		t.Fatal("split affected an endpoint outside the groups", err)
+	}
+	nw.Rejoin()
+	if _, err := a.Run(ctx, 0, nil); err != nil {
+		t.Fatal("unexpected", err)
+	}
+
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Run(ctx, 0, nil); err != partitiontest.ErrUnreachable {
+		t.Fatal("unexpected", err)
+	}
+}
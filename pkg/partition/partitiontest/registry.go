@@ -0,0 +1,165 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+// Package partitiontest provides in-process implementations of the
+// partition registry and network for tests.
+//
+// This allows a whole cluster of partition routers to be run in a
+// single process without Redis or TCP ports:
+//
+//      reg, nw := partitiontest.NewRegistry(), partitiontest.NewNetwork()
+//      opts := []partition.Option{partition.WithEndpointRegistry(reg), partition.WithNetwork(nw)}
+//      router1, err := partition.New(ctx, "one", handler1, opts...)
+//      router2, err := partition.New(ctx, "two", handler2, opts...)
+//
+// The network supports injecting latency, dropped requests and
+// network partitions.
+package partitiontest
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/tvastar/cluster/pkg/partition"
+)
+
+// Registry is an in-memory partition.EndpointRegistry.
+//
+// It also implements partition.MetadataRegistry,
+// partition.VersionedRegistry and partition.EndpointWatcher and
+// notifies watchers immediately of every change.
+type Registry struct {
+	sync.Mutex
+	endpoints []partition.Endpoint
+	version   uint64
+	watchers  map[*watcher]bool
+}
+
+// NewRegistry creates a new empty registry.
+func NewRegistry() *Registry {
+	return &Registry{watchers: map[*watcher]bool{}}
+}
+
+// RegisterEndpoint adds the address to the registry until the
+// returned io.Closer is closed.
+func (r *Registry) RegisterEndpoint(ctx context.Context, addr string) (io.Closer, error) {
+	return r.RegisterEndpointMetadata(ctx, partition.Endpoint{Addr: addr})
+}
+
+// RegisterEndpointMetadata implements partition.MetadataRegistry.
+func (r *Registry) RegisterEndpointMetadata(ctx context.Context, ep partition.Endpoint) (io.Closer, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.endpoints = append(r.endpoints, ep)
+	sort.Slice(r.endpoints, func(i, j int) bool {
+		return r.endpoints[i].Addr < r.endpoints[j].Addr
+	})
+	r.notify()
+	return closer(func() { r.remove(ep.Addr) }), nil
+}
+
+// ListEndpoints returns the currently registered endpoints.
+func (r *Registry) ListEndpoints(ctx context.Context, refresh bool) ([]string, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.list(), nil
+}
+
+// ListVersionedEndpoints implements partition.VersionedRegistry.
+// The version is incremented on every change.
+func (r *Registry) ListVersionedEndpoints(ctx context.Context, refresh bool) ([]string, uint64, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.list(), r.version, nil
+}
+
+// ListEndpointMetadata implements partition.MetadataRegistry.
+func (r *Registry) ListEndpointMetadata(ctx context.Context, refresh bool) ([]partition.Endpoint, error) {
+	r.Lock()
+	defer r.Unlock()
+	return append([]partition.Endpoint(nil), r.endpoints...), nil
+}
+
+// WatchEndpoints implements partition.EndpointWatcher.
+func (r *Registry) WatchEndpoints(ctx context.Context) (<-chan []string, error) {
+	w := &watcher{latest: make(chan []string, 1)}
+	result := make(chan []string)
+
+	r.Lock()
+	r.watchers[w] = true
+	w.latest <- r.list()
+	r.Unlock()
+
+	go func() {
+		defer close(result)
+		defer func() {
+			r.Lock()
+			delete(r.watchers, w)
+			r.Unlock()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case list := <-w.latest:
+				select {
+				case <-ctx.Done():
+					return
+				case result <- list:
+				}
+			}
+		}
+	}()
+	return result, nil
+}
+
+func (r *Registry) remove(addr string) {
+	r.Lock()
+	defer r.Unlock()
+
+	for kk, ep := range r.endpoints {
+		if ep.Addr == addr {
+			r.endpoints = append(r.endpoints[:kk:kk], r.endpoints[kk+1:]...)
+			r.notify()
+			return
+		}
+	}
+}
+
+func (r *Registry) list() []string {
+	result := make([]string, len(r.endpoints))
+	for kk, ep := range r.endpoints {
+		result[kk] = ep.Addr
+	}
+	return result
+}
+
+// notify increments the version and replaces any pending list of
+// every watcher with the current list.  This must be called with
+// the lock held.
+func (r *Registry) notify() {
+	r.version++
+	for w := range r.watchers {
+		select {
+		case <-w.latest:
+		default:
+		}
+		w.latest <- r.list()
+	}
+}
+
+type watcher struct {
+	latest chan []string
+}
+
+type closer func()
+
+func (c closer) Close() error {
+	c()
+	return nil
+}
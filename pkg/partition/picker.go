@@ -8,6 +8,7 @@ import (
 	"context"
 	"hash/crc32"
 	"strconv"
+	"sync"
 )
 
 // NewPicker returns a picker which uses a highest random weight algorithm
@@ -38,3 +39,42 @@ func checkWrite(n int, err error) {
 		panic(err)
 	}
 }
+
+// pickerStateKey is the context key for a pickerState.
+type pickerStateKey struct{}
+
+// pickerState holds the cached state of stateful pickers, such as
+// the ring built by NewHashRing for a list.
+//
+// Pickers use the state from the context when there is one instead
+// of their own. This allows picking from lists other than the one
+// used for routing (such as the old list of an OwnershipChange)
+// without evicting the routing list from the cache of the picker.
+type pickerState struct {
+	sync.Mutex
+	values map[interface{}]interface{}
+}
+
+func withPickerState(ctx context.Context, ps *pickerState) context.Context {
+	return context.WithValue(ctx, pickerStateKey{}, ps)
+}
+
+// localState returns the state of the picker identified by the key
+// from the context, creating it if needed. It returns nil if the
+// context has no picker state.
+func localState(ctx context.Context, key interface{}, create func() interface{}) interface{} {
+	ps, _ := ctx.Value(pickerStateKey{}).(*pickerState)
+	if ps == nil {
+		return nil
+	}
+
+	ps.Lock()
+	defer ps.Unlock()
+	if ps.values == nil {
+		ps.values = map[interface{}]interface{}{}
+	}
+	if _, ok := ps.values[key]; !ok {
+		ps.values[key] = create()
+	}
+	return ps.values[key]
+}
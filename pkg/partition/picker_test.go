@@ -0,0 +1,123 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+type picker = func(ctx context.Context, list []string, hash uint64) string
+
+func pickers() map[string]picker {
+	return map[string]picker{
+		"hrw":     NewPicker(),
+		"ring":    NewHashRing(),
+		"jump":    NewJumpHashPicker(),
+		"maglev":  NewMaglevPicker(0),
+		"bounded": NewBoundedLoadPicker(1.25),
+	}
+}
+
+func TestPickersBalanceAndStability(t *testing.T) {
+	ctx := context.Background()
+	list := []string{}
+	for kk := 0; kk < 10; kk++ {
+		list = append(list, fmt.Sprintf("10.0.0.%d:2222", kk))
+	}
+	reversed := []string{}
+	for kk := len(list) - 1; kk >= 0; kk-- {
+		reversed = append(reversed, list[kk])
+	}
+	shrunk := list[:len(list)-1]
+
+	for name, pick := range pickers() {
+		counts := map[string]int{}
+		picks := map[uint64]string{}
+		for hash := uint64(0); hash < 10000; hash++ {
+			picks[hash] = pick(ctx, list, hash)
+			counts[picks[hash]]++
+		}
+		for hash := uint64(0); hash < 10000; hash++ {
+			if x := pick(ctx, reversed, hash); x != picks[hash] {
+				t.Fatal(name, "depends on order", hash, x, picks[hash])
+			}
+		}
+		moved := 0
+		for hash := uint64(0); hash < 10000; hash++ {
+			if pick(ctx, shrunk, hash) != picks[hash] {
+				moved++
+			}
+		}
+
+		for _, addr := range list {
+			if counts[addr] < 500 || counts[addr] > 1500 {
+				t.Error(name, "unbalanced", addr, counts[addr])
+			}
+		}
+		if moved > 2*counts[list[len(list)-1]] {
+			t.Error(name, "moved too many", moved)
+		}
+	}
+}
+
+func BenchmarkPickers(b *testing.B) {
+	ctx := context.Background()
+	list := []string{}
+	for kk := 0; kk < 50; kk++ {
+		list = append(list, fmt.Sprintf("10.0.0.%d:2222", kk))
+	}
+
+	for name, pick := range pickers() {
+		b.Run(name, func(b *testing.B) {
+			for kk := 0; kk < b.N; kk++ {
+				pick(ctx, list, uint64(kk))
+			}
+		})
+	}
+}
+
+func TestWeightedPickers(t *testing.T) {
+	ctx := context.Background()
+	list := []Endpoint{{Addr: "small:2222"}, {Addr: "large:2222", Weight: 4}}
+	pickers := map[string]func(ctx context.Context, list []Endpoint, hash uint64) string{
+		"hrw":  NewWeightedPicker(),
+		"ring": NewWeightedHashRing(),
+	}
+
+	for name, pick := range pickers {
+		counts := map[string]int{}
+		for hash := uint64(0); hash < 10000; hash++ {
+			counts[pick(ctx, list, hash)]++
+		}
+		if counts["small:2222"] < 1500 || counts["small:2222"] > 2500 {
+			t.Error(name, "unexpected distribution", counts)
+		}
+	}
+}
+
+func TestPickerStateIsolation(t *testing.T) {
+	ctx := context.Background()
+	routing, other := []string{"a", "b"}, []string{"a", "b", "c"}
+
+	ring := &hashring{factor: 1000}
+	ring.Pick(ctx, routing, 5)
+	local := withPickerState(ctx, &pickerState{})
+	if ring.Pick(local, other, 5) != NewHashRing()(ctx, other, 5) {
+		t.Fatal("unexpected pick with picker state")
+	}
+	if listsDifferent(ring.list, routing) {
+		t.Fatal("picker state evicted the routing list", ring.list)
+	}
+
+	m := &maglev{size: 7}
+	m.Pick(ctx, routing, 5)
+	table := m.value.([]string)
+	m.Pick(local, other, 5)
+	if &m.value.([]string)[0] != &table[0] {
+		t.Fatal("picker state evicted the routing table")
+	}
+}
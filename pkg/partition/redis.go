@@ -6,9 +6,12 @@ package partition
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
+	"sort"
 	"time"
 
 	"github.com/go-redis/redis/v7"
@@ -16,84 +19,386 @@ import (
 
 // NewRedisRegistry returns a new registry based on Redis
 //
-// Note that this fetches the endpoint from redis on every call
-// A wrapper implementation can easily override this behaviour by
-// caching the results of the last call to ListEndpoints.
-func NewRedisRegistry(addr string, prefix string) EndpointRegistry {
-	ttl := time.Minute
-	return &redisreg{redis.NewClient(&redis.Options{Addr: addr}), prefix, ttl}
+// Note that this fetches the endpoint from redis on every call.
+// Use NewCachingRegistry to cache the results of ListEndpoints.
+//
+// Registered endpoints are kept alive by a heartbeat which runs
+// until the io.Closer returned by RegisterEndpoint is closed.
+// Endpoints are always listed sorted by address.
+//
+// The returned registry also implements io.Closer which closes the
+// connection to Redis once the registry is no longer used.
+func NewRedisRegistry(addr string, prefix string, opts ...RedisOption) EndpointRegistry {
+	r := &redisreg{
+		Client:  redis.NewClient(&redis.Options{Addr: addr}),
+		prefix:  prefix,
+		ttl:     time.Minute,
+		onError: logRedisError,
+		now:     time.Now,
+		after:   time.After,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.interval <= 0 || r.interval >= r.ttl {
+		r.interval = r.ttl / 3
+	}
+	return r
+}
+
+// RedisOption configures the redis registry.
+type RedisOption func(r *redisreg)
+
+// WithRedisTTL specifies how long an endpoint stays registered
+// without a heartbeat.
+//
+// The default is one minute.
+func WithRedisTTL(ttl time.Duration) RedisOption {
+	return func(r *redisreg) {
+		r.ttl = ttl
+	}
+}
+
+// WithRedisHeartbeat specifies how often a registered endpoint
+// refreshes its registration.
+//
+// The interval must be smaller than the TTL. The default is a third
+// of the TTL.
+func WithRedisHeartbeat(interval time.Duration) RedisOption {
+	return func(r *redisreg) {
+		r.interval = interval
+	}
+}
+
+// WithRedisErrorHandler specifies a callback for reporting the
+// health of the heartbeat.
+//
+// The callback is called with every error encountered by the
+// heartbeat and is called with nil once the heartbeat recovers
+// after a failure.
+//
+// The default is to log the errors.
+func WithRedisErrorHandler(fn func(err error)) RedisOption {
+	return func(r *redisreg) {
+		r.onError = fn
+	}
 }
 
 type redisreg struct {
 	*redis.Client
-	prefix string
-	ttl    time.Duration
+	prefix        string
+	ttl, interval time.Duration
+	onError       func(err error)
+
+	// these are overridden by tests
+	now   func() time.Time
+	after func(d time.Duration) <-chan time.Time
+}
+
+// Close closes the connection to Redis. The connection is shared by
+// all the registrations which must be closed first.
+func (r *redisreg) Close() error {
+	return r.Client.Close()
 }
 
 func (r *redisreg) RegisterEndpoint(ctx context.Context, addr string) (io.Closer, error) {
-	err := r.addEndpoint(addr)
+	return r.RegisterEndpointMetadata(ctx, Endpoint{Addr: addr})
+}
+
+func (r *redisreg) ListEndpoints(ctx context.Context, refresh bool) ([]string, error) {
+	return r.listEndpoints()
+}
+
+// RegisterEndpointMetadata implements MetadataRegistry.
+//
+// The metadata is stored as JSON in a companion hash keyed by the
+// address of the endpoint.
+func (r *redisreg) RegisterEndpointMetadata(ctx context.Context, ep Endpoint) (io.Closer, error) {
+	err := r.addEndpoint(ep)
 	if err != nil {
 		return nil, err
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	go r.refreshLoop(ctx, addr)
-	return cancelcloser(cancel), nil
+	done := make(chan struct{})
+	go r.refreshLoop(ctx, ep, done)
+	return cancelcloser{cancel, done}, nil
 }
 
-func (r *redisreg) ListEndpoints(ctx context.Context, refresh bool) ([]string, error) {
-	return r.listEndpoints()
+// ListVersionedEndpoints implements VersionedRegistry.
+//
+// The version is a counter which is incremented whenever endpoints
+// are added, removed or expire. Expired endpoints are purged before
+// listing so that the list always matches the version.
+func (r *redisreg) ListVersionedEndpoints(ctx context.Context, refresh bool) ([]string, uint64, error) {
+	res, err := listScript.Run(r.Client, r.keys(), fmt.Sprint(r.now().Unix())).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 3 {
+		return nil, 0, fmt.Errorf("partition: unexpected redis reply %v", res)
+	}
+	addrs, _ := values[0].([]interface{})
+	version, _ := values[1].(int64)
+	if purged, _ := values[2].(int64); purged > 0 {
+		r.notify()
+	}
+
+	list := make([]string, len(addrs))
+	for kk, addr := range addrs {
+		list[kk], _ = addr.(string)
+	}
+	sort.Strings(list)
+	return list, uint64(version), nil
 }
 
-func (r *redisreg) refreshLoop(ctx context.Context, addr string) {
-	defer r.Client.Close()
-	defer r.removeEndpoint(addr)
+// ListEndpointMetadata implements MetadataRegistry.
+func (r *redisreg) ListEndpointMetadata(ctx context.Context, refresh bool) ([]Endpoint, error) {
+	var list *redis.StringSliceCmd
+	var metadata *redis.StringStringMapCmd
+	_, err := r.Pipelined(func(pipe redis.Pipeliner) error {
+		list = pipe.ZRangeByScore(r.prefix+"endpoints", r.liveRange())
+		metadata = pipe.HGetAll(r.prefix + "metadata")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	timer := time.NewTimer(r.ttl / 3)
-	defer timer.Stop()
-	select {
-	case <-ctx.Done():
-		return
-	case <-timer.C:
-		if err := r.addEndpoint(addr); err != nil {
-			// report error better
-			log.Println("unexpected redis err", err)
+	addrs := list.Val()
+	sort.Strings(addrs)
+	result := make([]Endpoint, len(addrs))
+	for kk, addr := range addrs {
+		result[kk] = Endpoint{Addr: addr}
+		if data, ok := metadata.Val()[addr]; ok {
+			if err := json.Unmarshal([]byte(data), &result[kk]); err != nil {
+				return nil, err
+			}
 		}
 	}
+	return result, nil
 }
 
-func (r *redisreg) addEndpoint(addr string) error {
-	expires := float64(time.Now().Add(r.ttl).Unix())
-	_, err := r.ZAdd(r.prefix+"endpoints", &redis.Z{Score: expires, Member: addr}).Result()
+// WatchEndpoints implements EndpointWatcher.
+//
+// Membership changes are published on a Redis channel named after
+// the endpoints key. The list is also polled at the heartbeat
+// interval to catch endpoints which expire.
+//
+// This fails if the Redis server does not support pub/sub.
+func (r *redisreg) WatchEndpoints(ctx context.Context) (<-chan []string, error) {
+	pubsub := r.Subscribe(r.prefix + "endpoints")
+	if _, err := pubsub.Receive(); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	list, err := r.listEndpoints()
+	if err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	ch := make(chan []string, 1)
+	ch <- list
+	go r.watchLoop(ctx, pubsub, list, ch)
+	return ch, nil
+}
+
+func (r *redisreg) watchLoop(ctx context.Context, pubsub *redis.PubSub, last []string, ch chan []string) {
+	defer close(ch)
+	defer pubsub.Close()
+
+	notify := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-notify:
+		case <-r.after(r.interval):
+		}
+
+		list, err := r.listEndpoints()
+		if err != nil {
+			r.onError(err)
+			continue
+		}
+		if !listsDifferent(last, list) {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case ch <- list:
+			last = list
+		}
+	}
+}
+
+func (r *redisreg) refreshLoop(ctx context.Context, ep Endpoint, done chan struct{}) {
+	defer close(done)
+	defer r.removeEndpoint(ep.Addr)
+
+	delay, failures := r.interval, 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.after(delay):
+		}
+
+		if err := r.addEndpoint(ep); err != nil {
+			failures++
+			delay = r.backoff(failures)
+			r.onError(err)
+			continue
+		}
+
+		if failures > 0 {
+			r.onError(nil)
+		}
+		delay, failures = r.interval, 0
+	}
+}
+
+// backoff returns a jittered delay for retrying a failed
+// heartbeat. The delay grows exponentially with the number of
+// failures but never exceeds the heartbeat interval.
+func (r *redisreg) backoff(failures int) time.Duration {
+	delay := r.interval / 10
+	for kk := 1; kk < failures && delay < r.interval; kk++ {
+		delay *= 2
+	}
+	if delay > r.interval {
+		delay = r.interval
+	}
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+func (r *redisreg) addEndpoint(ep Endpoint) error {
+	data, err := json.Marshal(ep)
+	if err != nil {
+		return err
+	}
+
+	now, expires := r.now(), r.now().Add(r.ttl)
+	args := []interface{}{now.Unix(), expires.Unix(), ep.Addr, string(data), int64(r.ttl / time.Millisecond)}
+	changed, err := addScript.Run(r.Client, r.keys(), args...).Int64()
+	if err == nil && changed > 0 {
+		r.notify()
+	}
 	return err
 }
 
 func (r *redisreg) removeEndpoint(addr string) {
-	if _, err := r.ZRem(r.prefix+"endpoints", addr).Result(); err != nil {
-		log.Println("Unexpected redis err removing endpoint", err)
+	removed, err := removeScript.Run(r.Client, r.keys(), addr).Int64()
+	if err != nil {
+		r.onError(err)
+		return
+	}
+	if removed > 0 {
+		r.notify()
 	}
 }
 
+// keys returns the endpoints, metadata and version keys used by the
+// scripts.
+func (r *redisreg) keys() []string {
+	return []string{r.prefix + "endpoints", r.prefix + "metadata", r.prefix + "version"}
+}
+
+// purgeLua removes endpoints which stopped sending heartbeats
+// without deregistering, such as crashed servers, along with their
+// metadata. ARGV[1] is the current time.
+const purgeLua = `
+local expired = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+for _, addr in ipairs(expired) do
+	redis.call("HDEL", KEYS[2], addr)
+end
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+local changed = #expired
+`
+
+// addScript purges expired endpoints and adds (or refreshes) the
+// endpoint, incrementing the version if the membership changed. It
+// returns the number of endpoints added or purged.
+var addScript = redis.NewScript(purgeLua + `
+changed = changed + redis.call("ZADD", KEYS[1], ARGV[2], ARGV[3])
+redis.call("HSET", KEYS[2], ARGV[3], ARGV[4])
+redis.call("PEXPIRE", KEYS[1], ARGV[5])
+redis.call("PEXPIRE", KEYS[2], ARGV[5])
+if changed > 0 then
+	redis.call("INCR", KEYS[3])
+end
+return changed
+`)
+
+// removeScript removes the endpoint and increments the version if
+// it was registered.
+var removeScript = redis.NewScript(`
+local removed = redis.call("ZREM", KEYS[1], ARGV[1])
+redis.call("HDEL", KEYS[2], ARGV[1])
+if removed > 0 then
+	redis.call("INCR", KEYS[3])
+end
+return removed
+`)
+
+// listScript purges expired endpoints, incrementing the version if
+// any were purged, and returns the endpoints, the version and the
+// number of endpoints purged.
+var listScript = redis.NewScript(purgeLua + `
+if changed > 0 then
+	redis.call("INCR", KEYS[3])
+end
+local version = tonumber(redis.call("GET", KEYS[3]) or "0")
+return {redis.call("ZRANGE", KEYS[1], 0, -1), version, changed}
+`)
+
+// notify notifies watchers of a membership change.
+//
+// Errors are ignored as watchers also poll for changes.
+func (r *redisreg) notify() {
+	r.Publish(r.prefix+"endpoints", "changed")
+}
+
+// listEndpoints lists the live endpoints sorted by address. The
+// sorted set itself is ordered by expiry which changes with every
+// heartbeat.
 func (r *redisreg) listEndpoints() ([]string, error) {
-	rangeBy := redis.ZRangeBy{
-		Min: fmt.Sprint(time.Now().Unix()),
+	list, err := r.ZRangeByScore(r.prefix+"endpoints", r.liveRange()).Result()
+	sort.Strings(list)
+	return list, err
+}
+
+// liveRange is the range of scores of endpoints which have not
+// expired
+func (r *redisreg) liveRange() *redis.ZRangeBy {
+	return &redis.ZRangeBy{
+		Min: fmt.Sprint(r.now().Unix()),
 		Max: "Inf",
 	}
-
-	return r.ZRangeByScore(r.prefix+"endpoints", &rangeBy).Result()
 }
 
-func (r *redisreg) purgeEndpoints() {
-	expires := fmt.Sprint(time.Now().Unix())
-	_, err := r.ZRemRangeByScore(r.prefix+"endpoints", "0", expires).Result()
+func logRedisError(err error) {
 	if err != nil {
-		log.Println("Unexpected redis err removing stale endpoints", err)
+		log.Println("unexpected redis err", err)
 	}
 }
 
-type cancelcloser func()
+// cancelcloser cancels a background goroutine and waits for it to
+// finish
+type cancelcloser struct {
+	cancel func()
+	done   <-chan struct{}
+}
 
 func (c cancelcloser) Close() error {
-	c()
+	c.cancel()
+	<-c.done
 	return nil
 }
@@ -0,0 +1,287 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+)
+
+func TestRedisHeartbeat(t *testing.T) {
+	minir, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer minir.Close()
+
+	clock := newFakeClock()
+	r := NewRedisRegistry(minir.Addr(), "prefix_").(*redisreg)
+	r.now, r.after = clock.Now, clock.After
+
+	ctx := context.Background()
+	closer, err := r.RegisterEndpoint(ctx, "boo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for elapsed := time.Duration(0); elapsed < 3*time.Hour; {
+		d := clock.tick()
+		minir.FastForward(d)
+		elapsed += d
+	}
+
+	// wait for the last heartbeat to complete
+	clock.wait()
+	if !minir.Exists("prefix_endpoints") {
+		t.Fatal("endpoints key expired")
+	}
+
+	list, err := r.ListEndpoints(ctx, false)
+	if err != nil || len(list) != 1 || list[0] != "boo" {
+		t.Fatal("unexpected list", list, err)
+	}
+
+	go clock.drain()
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the registry remains usable after the registration ends
+	if list, err = r.listEndpoints(); err != nil || len(list) != 0 {
+		t.Fatal("unexpected list", list, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if list, err = r.listEndpoints(); err == nil {
+		t.Fatal("client unexpectedly still open", list)
+	}
+}
+
+func TestRedisHeartbeatErrors(t *testing.T) {
+	minir, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer minir.Close()
+
+	var errs []error
+	clock := newFakeClock()
+	onError := WithRedisErrorHandler(func(err error) { errs = append(errs, err) })
+	r := NewRedisRegistry(minir.Addr(), "prefix_", onError).(*redisreg)
+	r.now, r.after = clock.Now, clock.After
+
+	closer, err := r.RegisterEndpoint(context.Background(), "boo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	minir.Close()
+	clock.tick()
+	d := clock.tick()
+	if d >= r.interval {
+		t.Error("retry did not backoff", d)
+	}
+
+	clock.wait()
+	if err := minir.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	clock.tick()
+	clock.wait()
+
+	if len(errs) != 3 || errs[0] == nil || errs[1] == nil || errs[2] != nil {
+		t.Fatal("unexpected errors", errs)
+	}
+
+	go clock.drain()
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type fakeTimer struct {
+	d time.Duration
+	c chan time.Time
+}
+
+// fakeClock is a clock where time only advances when a timer is
+// fired via tick.
+type fakeClock struct {
+	timers chan fakeTimer
+	sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{timers: make(chan fakeTimer), now: time.Now()}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.timers <- fakeTimer{d, ch}
+	return ch
+}
+
+// tick waits for the next timer, advances the clock and fires the
+// timer.  It returns the duration of the timer.
+func (c *fakeClock) tick() time.Duration {
+	timer := <-c.timers
+	c.Lock()
+	c.now = c.now.Add(timer.d)
+	now := c.now
+	c.Unlock()
+	timer.c <- now
+	return timer.d
+}
+
+// wait waits for the next timer to be created without firing it.
+func (c *fakeClock) wait() {
+	timer := <-c.timers
+	go func() { c.timers <- timer }()
+}
+
+// drain accepts timers without firing them
+func (c *fakeClock) drain() {
+	for range c.timers {
+	}
+}
+
+func TestRedisMetadata(t *testing.T) {
+	minir, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer minir.Close()
+
+	r := NewRedisRegistry(minir.Addr(), "prefix_").(*redisreg)
+	ctx := context.Background()
+	ep := Endpoint{
+		Addr:    "boo",
+		Zone:    "us-west1-a",
+		Version: "v1.2.3",
+		Weight:  5,
+		Labels:  map[string]string{"canary": "true"},
+	}
+	closer, err := r.RegisterEndpointMetadata(ctx, ep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eps, err := r.ListEndpointMetadata(ctx, false)
+	if err != nil || !reflect.DeepEqual(eps, []Endpoint{ep}) {
+		t.Fatal("unexpected", eps, err)
+	}
+
+	// crashed endpoints lose their metadata once they expire
+	now := r.now
+	r.now = func() time.Time { return now().Add(-time.Hour) }
+	if err := r.addEndpoint(Endpoint{Addr: "crashed", Zone: "us-west1-b"}); err != nil {
+		t.Fatal(err)
+	}
+	r.now = now
+	if err := r.addEndpoint(ep); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := minir.HKeys("prefix_metadata"); err != nil || len(keys) != 1 {
+		t.Fatal("metadata of expired endpoint not removed", keys, err)
+	}
+
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if minir.Exists("prefix_metadata") {
+		t.Fatal("metadata not removed")
+	}
+}
+
+func TestRedisVersion(t *testing.T) {
+	minir, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer minir.Close()
+
+	r := NewRedisRegistry(minir.Addr(), "prefix_").(*redisreg)
+	ctx := context.Background()
+	check := func(expected []string, version uint64) {
+		t.Helper()
+		list, v, err := r.ListVersionedEndpoints(ctx, false)
+		if err != nil || !reflect.DeepEqual(list, expected) || v != version {
+			t.Fatal("unexpected", list, v, err)
+		}
+	}
+
+	check([]string{}, 0)
+	if err := r.addEndpoint(Endpoint{Addr: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"a"}, 1)
+
+	// heartbeats do not change the version
+	if err := r.addEndpoint(Endpoint{Addr: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"a"}, 1)
+
+	// crashed endpoints bump the version once they expire
+	now := r.now
+	r.now = func() time.Time { return now().Add(-time.Hour) }
+	if err := r.addEndpoint(Endpoint{Addr: "crashed"}); err != nil {
+		t.Fatal(err)
+	}
+	r.now = now
+	check([]string{"a"}, 3)
+
+	r.removeEndpoint("a")
+	check([]string{}, 4)
+	r.removeEndpoint("a")
+	check([]string{}, 4)
+}
+
+func TestRedisListOrder(t *testing.T) {
+	minir, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer minir.Close()
+
+	r := NewRedisRegistry(minir.Addr(), "prefix_").(*redisreg)
+	ctx := context.Background()
+
+	// heartbeats reorder the sorted set by expiry
+	now := r.now
+	for kk, addr := range []string{"b", "a", "c", "b"} {
+		offset := time.Duration(kk) * time.Second
+		r.now = func() time.Time { return now().Add(offset) }
+		if err := r.addEndpoint(Endpoint{Addr: addr}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.now = now
+
+	expected := []string{"a", "b", "c"}
+	if list, err := r.ListEndpoints(ctx, false); err != nil || !reflect.DeepEqual(list, expected) {
+		t.Fatal("unexpected", list, err)
+	}
+	if list, _, err := r.ListVersionedEndpoints(ctx, false); err != nil || !reflect.DeepEqual(list, expected) {
+		t.Fatal("unexpected", list, err)
+	}
+	eps, err := r.ListEndpointMetadata(ctx, false)
+	if err != nil || !reflect.DeepEqual(addrsOf(eps), expected) {
+		t.Fatal("unexpected", eps, err)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// NewRedisLeaseStore returns a lease store based on Redis.
+//
+// Each lease is a single key holding the address of the owner which
+// expires with the lease. Fencing tokens are issued from a separate
+// counter per partition which never expires.
+func NewRedisLeaseStore(addr string, prefix string) LeaseStore {
+	return &redislease{redis.NewClient(&redis.Options{Addr: addr}), prefix}
+}
+
+type redislease struct {
+	*redis.Client
+	prefix string
+}
+
+// acquireScript takes the lease if it is free, issuing a new
+// fencing token, or extends it if it is already held by the owner.
+// It returns the fencing token or 0 if the lease is held by another
+// owner.
+var acquireScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return tonumber(redis.call("GET", KEYS[2]))
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0
+`)
+
+// renewScript extends the lease only if it is held by the owner
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript deletes the lease only if it is held by the owner
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func (r *redislease) Acquire(ctx context.Context, partition int, owner string, ttl time.Duration) (uint64, bool, error) {
+	keys := []string{r.key(partition), r.prefix + "fence:" + strconv.Itoa(partition)}
+	fence, err := acquireScript.Run(r.WithContext(ctx), keys, owner, int64(ttl/time.Millisecond)).Uint64()
+	return fence, fence > 0, err
+}
+
+func (r *redislease) Renew(ctx context.Context, partition int, owner string, ttl time.Duration) (bool, error) {
+	keys := []string{r.key(partition)}
+	n, err := renewScript.Run(r.WithContext(ctx), keys, owner, int64(ttl/time.Millisecond)).Int()
+	return n == 1, err
+}
+
+func (r *redislease) Release(ctx context.Context, partition int, owner string) error {
+	keys := []string{r.key(partition)}
+	return releaseScript.Run(r.WithContext(ctx), keys, owner).Err()
+}
+
+func (r *redislease) key(partition int) string {
+	return r.prefix + "lease:" + strconv.Itoa(partition)
+}
@@ -0,0 +1,190 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// ReadPolicy specifies how RunReplicated combines the responses of
+// the replicas.
+type ReadPolicy int
+
+const (
+	// FirstSuccess tries the replicas one at a time in rank order
+	// and returns the first successful response.
+	FirstSuccess ReadPolicy = iota
+
+	// Quorum sends the request to all the replicas and succeeds
+	// once a majority of them succeed.
+	Quorum
+
+	// All sends the request to all the replicas and only succeeds
+	// if every one of them succeeds.
+	All
+)
+
+// NewRanker returns a ranker which orders endpoints by their highest
+// random weight score. The first endpoint is always the one picked
+// by NewPicker.
+func NewRanker() func(ctx context.Context, list []string, hash uint64, n int) []string {
+	return hrwRanker
+}
+
+func hrwRanker(ctx context.Context, list []string, hash uint64, n int) []string {
+	scores := make(map[string]uint32, len(list))
+	h := crc32.NewIEEE()
+	for _, candidate := range list {
+		checkWrite(h.Write([]byte(strconv.FormatUint(hash, 16))))
+		checkWrite(h.Write([]byte(candidate)))
+		scores[candidate] = h.Sum32()
+		h.Reset()
+	}
+
+	ranked := append([]string(nil), list...)
+	sort.Slice(ranked, func(i, j int) bool {
+		si, sj := scores[ranked[i]], scores[ranked[j]]
+		return si > sj || si == sj && ranked[i] < ranked[j]
+	})
+	if n < len(ranked) {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+// NewHashRingRanker returns a ranker which orders endpoints by their
+// successive positions on the hash ring. The first endpoint is
+// always the one picked by NewHashRing.
+func NewHashRingRanker() func(ctx context.Context, list []string, hash uint64, n int) []string {
+	ring := &hashring{factor: 1000}
+	return func(ctx context.Context, list []string, hash uint64, n int) []string {
+		if len(list) == 0 {
+			return nil
+		}
+
+		hashes, lookup := ring.getSortedHashes(list)
+		start := ringIndex(hashes, hash)
+		ranked := []string(nil)
+		seen := map[string]bool{}
+		for kk := 0; kk < len(hashes) && len(ranked) < n && len(seen) < len(list); kk++ {
+			candidate := lookup[hashes[(start+kk)%len(hashes)]]
+			if !seen[candidate] {
+				seen[candidate] = true
+				ranked = append(ranked, candidate)
+			}
+		}
+		return ranked
+	}
+}
+
+var errNoEndpoints = fmt.Errorf("partition: no endpoints")
+
+// RunReplicated implements Router.RunReplicated.
+func (s *state) RunReplicated(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+	refresh := false
+	for attempt := 1; ; attempt++ {
+		result, err := s.runReplicated(ctx, hash, input, refresh)
+		if _, ok := err.(IncorrectPartitionError); !ok || !s.retry.wait(ctx, attempt) {
+			return result, err
+		}
+		s.adopt(err)
+		refresh = true
+	}
+}
+
+func (s *state) runReplicated(ctx context.Context, hash uint64, input []byte, refresh bool) ([]byte, error) {
+	owners, err := s.getOwners(ctx, hash, s.replicas, refresh)
+	if err != nil {
+		return nil, err
+	}
+	if len(owners) == 0 {
+		return nil, errNoEndpoints
+	}
+
+	switch s.readPolicy {
+	case Quorum:
+		return s.runAll(ctx, owners, hash, input, len(owners)/2+1)
+	case All:
+		return s.runAll(ctx, owners, hash, input, len(owners))
+	}
+
+	errs := errors{}
+	for _, addr := range owners {
+		result, err := s.runOn(ctx, addr, hash, input)
+		if err == nil {
+			return result, nil
+		}
+		errs.check(err)
+	}
+	return nil, errs.toError()
+}
+
+// runAll sends the request to all the owners in parallel and
+// returns the response of the highest ranked owner once the
+// required number of owners have succeeded.
+func (s *state) runAll(ctx context.Context, owners []string, hash uint64, input []byte, need int) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type reply struct {
+		rank   int
+		output []byte
+		err    error
+	}
+
+	replies := make(chan reply, len(owners))
+	for rank, addr := range owners {
+		go func(rank int, addr string) {
+			output, err := s.runOn(ctx, addr, hash, input)
+			replies <- reply{rank, output, err}
+		}(rank, addr)
+	}
+
+	errs := errors{}
+	best, succeeded := reply{rank: len(owners)}, 0
+	for range owners {
+		r := <-replies
+		if r.err != nil {
+			if errs.check(r.err); len(errs) > len(owners)-need {
+				return nil, errs.toError()
+			}
+			continue
+		}
+
+		if succeeded++; r.rank < best.rank {
+			best = r
+		}
+		if succeeded == need {
+			break
+		}
+	}
+	return best.output, nil
+}
+
+// getOwners returns the top n ranked endpoints for the hash.
+//
+// Rankers only see the addresses of the endpoints even when
+// WithEndpointPicker is used.
+func (s *state) getOwners(ctx context.Context, hash uint64, n int, refresh bool) ([]string, error) {
+	ctx = context.WithValue(ctx, loadsKey{}, &s.loads)
+	hash = s.partitionOf(hash)
+	if s.pickWithMetadata != nil {
+		eps, err := s.listMetadata(ctx, refresh)
+		if err != nil {
+			return nil, err
+		}
+		return s.rank(ctx, addrsOf(eps), hash, n), nil
+	}
+
+	list, err := s.listEndpoints(ctx, refresh)
+	if err != nil {
+		return nil, err
+	}
+	return s.rank(ctx, list, hash, n), nil
+}
@@ -0,0 +1,64 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestRankers(t *testing.T) {
+	ctx := context.Background()
+	list := []string{"a", "b", "c", "d", "e"}
+	rankers := map[string]struct {
+		pick picker
+		rank func(ctx context.Context, list []string, hash uint64, n int) []string
+	}{
+		"hrw":  {NewPicker(), NewRanker()},
+		"ring": {NewHashRing(), NewHashRingRanker()},
+	}
+
+	for name, r := range rankers {
+		for hash := uint64(0); hash < 1000; hash++ {
+			ranked := r.rank(ctx, list, hash, 3)
+			if len(ranked) != 3 || ranked[0] != r.pick(ctx, list, hash) {
+				t.Fatal(name, "unexpected ranking", hash, ranked)
+			}
+			if ranked[0] == ranked[1] || ranked[1] == ranked[2] || ranked[0] == ranked[2] {
+				t.Fatal(name, "duplicate replicas", hash, ranked)
+			}
+			if all := r.rank(ctx, list, hash, 10); len(all) != len(list) {
+				t.Fatal(name, "unexpected ranking", hash, all)
+			}
+		}
+	}
+}
+
+func TestRunReplicated(t *testing.T) {
+	list := []string{"a", "b", "c"}
+	ranked := NewRanker()(context.Background(), list, 5, 3)
+	reg := stubRegistry{false: list, true: list}
+	nw := stubNetwork{
+		ranked[0]: stubRunner(func() ([]byte, error) { return nil, fmt.Errorf("failed") }),
+		ranked[1]: stubRunner(func() ([]byte, error) { return []byte(ranked[1]), nil }),
+		ranked[2]: stubRunner(func() ([]byte, error) { return []byte(ranked[2]), nil }),
+	}
+	ctx := context.Background()
+
+	cases := map[ReadPolicy]string{FirstSuccess: ranked[1], Quorum: ranked[1], All: ""}
+	for policy, expected := range cases {
+		r, err := New(ctx, "", nil, WithEndpointRegistry(reg), WithNetwork(nw), WithReplicas(3), WithReadPolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		res, err := r.RunReplicated(ctx, 5, nil)
+		if string(res) != expected || (err != nil) != (expected == "") {
+			t.Error(policy, "unexpected", string(res), err)
+		}
+	}
+}
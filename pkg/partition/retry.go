@@ -0,0 +1,70 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy specifies how requests which end up on the wrong
+// partition are retried.
+//
+// Every retry refreshes the list of endpoints before picking the
+// endpoint again.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the
+	// first one. Zero or one disables retries.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry. This is
+	// doubled on every subsequent retry.
+	Backoff time.Duration
+
+	// MaxBackoff caps the delay between retries. Zero implies no
+	// cap.
+	MaxBackoff time.Duration
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     10 * time.Millisecond,
+	MaxBackoff:  time.Second,
+}
+
+// wait sleeps before the next attempt. It returns false if no
+// further attempts should be made: either the policy is exhausted,
+// the context is done or the context deadline would expire before
+// the next attempt.
+func (p RetryPolicy) wait(ctx context.Context, attempt int) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+
+	delay := p.Backoff
+	for kk := 1; kk < attempt && (p.MaxBackoff == 0 || delay < p.MaxBackoff); kk++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	if delay > 0 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+		return false
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
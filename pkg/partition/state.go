@@ -8,6 +8,7 @@ import (
 	"context"
 	"io"
 	"sync"
+	"time"
 )
 
 type state struct {
@@ -15,38 +16,136 @@ type state struct {
 	addr    string
 	handler Runner
 
-	serverCloser, epCloser io.Closer
+	serverCloser, epCloser, watchCloser io.Closer
+	leaseCloser                         io.Closer
+	loads                               loads
+	latencies                           latencies
+	leases                              leases
+
+	// migrateCtx is canceled when the router is closed
+	migrateCtx     context.Context
+	stopMigrations func()
 
 	sync.Mutex
-	clients map[string]RunCloser
+	clients          map[string]*conn
+	observed         []string
+	observedEps      []Endpoint
+	changes          []*OwnershipChange
+	notifying        bool
+	migrations       []*migration // pending in the order of changes
+	version          uint64       // newest version seen from the registry
+	hint             []string     // newer list provided by other endpoints
+	hintVersion      uint64
+	watching         bool     // set while the watch is active
+	endpoints        []string // only used with EndpointWatcher
+	endpointsMeta    []Endpoint
+	endpointsVersion uint64
+	draining         bool
+	inflight         int
+	running          int               // runs of the local handler
+	generation       uint64            // incremented with every run
+	exported         map[string]uint64 // generation pulled by peers while draining
 }
 
 func (s *state) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
-	addr, err := s.getAddr(ctx, hash, false)
+	redirect, refresh := "", false
+	for attempt := 1; ; attempt++ {
+		result, err := s.run(ctx, hash, input, redirect, refresh)
+		e, ok := err.(IncorrectPartitionError)
+		if !ok {
+			return result, err
+		}
+		s.adopt(err)
+
+		// redirect right away if the endpoint knows the owner
+		// unless the request was already redirected
+		owner := s.redirectTo(e)
+		if owner != "" && redirect == "" && attempt < s.retry.MaxAttempts {
+			redirect = owner
+			continue
+		}
+		if !s.retry.wait(ctx, attempt) {
+			return result, err
+		}
+		redirect, refresh = owner, true
+	}
+}
+
+// redirectTo returns the owner provided by the error if it is one
+// of the known endpoints. Other addresses are not used to avoid
+// dialing arbitrary endpoints.
+func (s *state) redirectTo(e IncorrectPartitionError) string {
+	s.Lock()
+	defer s.Unlock()
+	for _, list := range [][]string{s.observed, s.hint} {
+		for _, addr := range list {
+			if addr == e.Owner {
+				return addr
+			}
+		}
+	}
+	return ""
+}
+
+// run runs the request on the owner of the hash or on the redirect
+// address if one is provided.
+func (s *state) run(ctx context.Context, hash uint64, input []byte, redirect string, refresh bool) ([]byte, error) {
+	if redirect != "" {
+		return s.runOn(ctx, redirect, hash, input)
+	}
+
+	addr, err := s.getAddr(ctx, hash, refresh)
 	if err != nil {
 		return nil, err
 	}
+	if s.hedging() {
+		return s.runHedged(ctx, addr, hash, input, refresh)
+	}
+	return s.runOn(ctx, addr, hash, input)
+}
 
-	s.Lock()
-	if _, ok := s.clients[addr]; !ok && s.clients != nil {
-		s.clients[addr], err = s.DialClient(ctx, addr)
+// runOn runs the request on the specific endpoint.
+func (s *state) runOn(ctx context.Context, addr string, hash uint64, input []byte) ([]byte, error) {
+	if s.isLocal(addr) {
+		s.loads.start(addr)
+		defer s.loads.done(addr)
+		return safe{s}.Run(ctx, hash, input)
 	}
-	c := s.clients[addr]
-	s.Unlock()
 
+	c, release, err := s.client(ctx, addr)
 	if err != nil {
 		return nil, err
 	}
-	return c.Run(ctx, hash, input)
+	defer release()
+
+	s.loads.start(addr)
+	defer s.loads.done(addr)
+	return c.Run(s.withEpoch(ctx), hash, input)
+}
+
+// isLocal checks if requests to the endpoint can be handled
+// directly instead of going through the network.
+func (s *state) isLocal(addr string) bool {
+	return s.localShortCircuit && s.handler != nil && addr == s.addr
 }
 
 func (s *state) Close() error {
+	errs := errors{}
+	s.stopMigrations()
+	if s.watchCloser != nil {
+		errs.check(s.watchCloser.Close())
+	}
+	if s.leaseCloser != nil {
+		errs.check(s.leaseCloser.Close())
+	}
+
 	s.Lock()
 	defer s.Unlock()
 
-	errs := errors{}
-	for _, runcloser := range s.clients {
-		errs.check(runcloser.Close())
+	for _, c := range s.clients {
+		if c.client != nil {
+			errs.check(c.client.Close())
+		}
 	}
 	s.clients = nil
 
@@ -62,7 +161,9 @@ func (s *state) Close() error {
 	return errs.toError()
 }
 
-func (s *state) init(ctx context.Context) (RunCloser, error) {
+func (s *state) init(ctx context.Context) (Router, error) {
+	s.migrateCtx, s.stopMigrations = context.WithCancel(context.Background())
+
 	var err error
 	defer func() {
 		if err != nil {
@@ -76,22 +177,169 @@ func (s *state) init(ctx context.Context) (RunCloser, error) {
 			return nil, err
 		}
 
-		s.epCloser, err = s.RegisterEndpoint(ctx, s.addr)
+		s.epCloser, err = s.register(ctx)
 		if err != nil {
 			return nil, err
 		}
 	}
-	s.clients = map[string]RunCloser{}
+	s.clients = map[string]*conn{}
+
+	if w, ok := s.EndpointRegistry.(EndpointWatcher); ok {
+		s.watch(w)
+	}
+	if s.handler != nil && s.leaseStore != nil {
+		s.maintainLeases()
+	}
 	return s, nil
 }
 
+// register adds the local endpoint along with its metadata to the
+// registry.
+func (s *state) register(ctx context.Context) (io.Closer, error) {
+	ep := s.metadata
+	ep.Addr = s.addr
+	return registerEndpointMetadata(ctx, s.EndpointRegistry, ep)
+}
+
+// watch subscribes to membership changes from the registry.
+//
+// If the watch fails, does not deliver the first list within
+// watchTimeout or ends before the router is closed, the registry is
+// polled instead.
+func (s *state) watch(w EndpointWatcher) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := w.WatchEndpoints(ctx)
+	if err != nil {
+		cancel()
+		return
+	}
+
+	var list []string
+	ok := false
+	select {
+	case list, ok = <-ch:
+	case <-time.After(watchTimeout):
+	}
+	if !ok {
+		cancel()
+		return
+	}
+
+	done := make(chan struct{})
+	s.watchCloser = cancelcloser{cancel, done}
+	s.setEndpoints(ctx, list)
+
+	go func() {
+		defer close(done)
+		for list := range ch {
+			s.setEndpoints(ctx, list)
+		}
+
+		s.Lock()
+		defer s.Unlock()
+		s.watching = false
+	}()
+}
+
+// watchTimeout bounds the wait for the first list from a watch.
+var watchTimeout = 5 * time.Second
+
+// setEndpoints updates the watched list of endpoints. The list is
+// fetched again along with its version if the registry supports
+// versions and along with the metadata if the picker uses it.
+func (s *state) setEndpoints(ctx context.Context, list []string) {
+	version := uint64(0)
+	if v, ok := s.EndpointRegistry.(VersionedRegistry); ok {
+		if l, ver, err := v.ListVersionedEndpoints(ctx, false); err == nil {
+			list, version = l, ver
+		}
+	}
+
+	var eps []Endpoint
+	if s.pickWithMetadata != nil {
+		if e, err := listEndpointMetadata(ctx, s.EndpointRegistry, false); err == nil {
+			list, eps = addrsOf(e), e
+		}
+	}
+
+	s.Lock()
+	s.watching, s.endpoints, s.endpointsVersion = true, list, version
+	s.endpointsMeta = eps
+	if version > s.version {
+		s.version = version
+	}
+	s.Unlock()
+	s.observe(list, eps)
+}
+
 func (s *state) getAddr(ctx context.Context, hash uint64, refresh bool) (string, error) {
-	eps, err := s.ListEndpoints(ctx, false)
+	pick, err := s.addrPicker(ctx, refresh)
 	if err != nil {
 		return "", err
 	}
+	return pick(hash), nil
+}
+
+// addrPicker lists the endpoints once and returns a function which
+// picks the owner of any hash from that list.
+func (s *state) addrPicker(ctx context.Context, refresh bool) (func(hash uint64) string, error) {
+	ctx = context.WithValue(ctx, loadsKey{}, &s.loads)
+	if s.pickWithMetadata != nil {
+		eps, err := s.listMetadata(ctx, refresh)
+		if err != nil {
+			return nil, err
+		}
+		return func(hash uint64) string {
+			return s.pickWithMetadata(ctx, eps, s.partitionOf(hash))
+		}, nil
+	}
 
-	return s.pickEndpoint(ctx, eps, hash), nil
+	list, err := s.listEndpoints(ctx, refresh)
+	if err != nil {
+		return nil, err
+	}
+	return func(hash uint64) string {
+		return s.pickEndpoint(ctx, list, s.partitionOf(hash))
+	}, nil
+}
+
+// listEndpoints uses the watched list of endpoints unless a refresh
+// is requested or there is no active watch.
+func (s *state) listEndpoints(ctx context.Context, refresh bool) ([]string, error) {
+	if !refresh {
+		s.Lock()
+		if s.watching {
+			defer s.Unlock()
+			return s.newest(s.endpoints, s.endpointsVersion), nil
+		}
+		s.Unlock()
+	}
+
+	list, err := s.listVersioned(ctx, refresh)
+	if err == nil {
+		s.observe(list, nil)
+	}
+	return list, err
+}
+
+// listMetadata lists the endpoints along with their metadata,
+// using the watched list unless a refresh is requested or there is
+// no active watch.
+func (s *state) listMetadata(ctx context.Context, refresh bool) ([]Endpoint, error) {
+	if !refresh {
+		s.Lock()
+		if s.watching && s.endpointsMeta != nil {
+			defer s.Unlock()
+			return s.endpointsMeta, nil
+		}
+		s.Unlock()
+	}
+
+	eps, err := listEndpointMetadata(ctx, s.EndpointRegistry, refresh)
+	if err == nil {
+		s.observe(addrsOf(eps), eps)
+	}
+	return eps, err
 }
 
 // safe implements a Runner the first verifies if the request has the
@@ -101,16 +349,85 @@ type safe struct {
 }
 
 func (s safe) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
-	addr, err := s.getAddr(ctx, hash, false)
-	if err != nil {
-		return nil, err
+	ctx, h := receive(ctx)
+	return s.run(ctx, h, hash, input)
+}
+
+func (s safe) run(ctx context.Context, h header, hash uint64, input []byte) ([]byte, error) {
+	if !s.enter() {
+		return nil, IncorrectPartitionError{}
 	}
-	if addr != s.addr {
-		addr, err = s.getAddr(ctx, hash, true)
-		if err != nil || addr != s.addr {
-			return nil, IncorrectPartitionError{}
-		}
+	defer s.exit()
+
+	if err := s.check(ctx, h, hash); err != nil {
+		return s.forward(ctx, h, hash, input, err)
+	}
+	return s.runOwned(ctx, h, hash, input)
+}
+
+// runOwned runs a request which belongs to the local endpoint,
+// handing it off to the previous owner while its state is migrated
+// and holding the lease if leases are used.
+func (s safe) runOwned(ctx context.Context, h header, hash uint64, input []byte) ([]byte, error) {
+	if handed, output, err := s.runOnPrevious(ctx, h, hash, input); handed {
+		return output, err
 	}
 
+	if s.leaseStore != nil && !h.broadcast {
+		ls, done, err := s.lease(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		defer done()
+		var cancel func()
+		ctx, cancel = withLease(ctx, ls)
+		defer cancel()
+	}
+	defer s.handle()()
 	return s.handler.Run(ctx, hash, input)
 }
+
+// check verifies that the request belongs to the local endpoint.
+func (s safe) check(ctx context.Context, h header, hash uint64) error {
+	if h.broadcast || h.handoff {
+		return nil
+	}
+
+	// refresh right away if the caller has a newer version
+	refresh := h.epoch > s.currentVersion()
+	check := context.WithValue(ctx, ownerCheckKey{}, s.addr)
+	owns, err := s.owns(check, h, hash, refresh)
+	if err != nil {
+		return err
+	}
+	if !owns && !refresh {
+		owns, err = s.owns(check, h, hash, true)
+	}
+	if err != nil || !owns {
+		return s.incorrect(check, h, hash)
+	}
+	return nil
+}
+
+// owns checks if the local endpoint is the owner or one of the
+// replicas of the hash.  The second ranked endpoint is also
+// accepted for hedged requests.
+func (s safe) owns(ctx context.Context, h header, hash uint64, refresh bool) (bool, error) {
+	n := s.replicas
+	if h.hedged && n < 2 {
+		n = 2
+	}
+
+	addr, err := s.getAddr(ctx, hash, refresh)
+	if err != nil || addr == s.addr || n <= 1 {
+		return addr == s.addr, err
+	}
+
+	owners, err := s.getOwners(ctx, hash, n, refresh)
+	for _, owner := range owners {
+		if owner == s.addr {
+			return true, nil
+		}
+	}
+	return false, err
+}
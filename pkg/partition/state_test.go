@@ -0,0 +1,394 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryIncorrectPartition(t *testing.T) {
+	reg := stubRegistry{false: {"a"}, true: {"b"}}
+	nw := stubNetwork{
+		"a": stubRunner(func() ([]byte, error) { return nil, IncorrectPartitionError{} }),
+		"b": stubRunner(func() ([]byte, error) { return []byte("ok"), nil }),
+	}
+	ctx := context.Background()
+
+	r, err := New(ctx, "", nil, WithEndpointRegistry(reg), WithNetwork(nw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if res, err := r.Run(ctx, 5, nil); err != nil || string(res) != "ok" {
+		t.Fatal("unexpected", string(res), err)
+	}
+
+	noRetries := WithRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	r, err = New(ctx, "", nil, WithEndpointRegistry(reg), WithNetwork(nw), noRetries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, err := r.Run(ctx, 5, nil); err != (IncorrectPartitionError{}) {
+		t.Fatal("unexpected", err)
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	reg := stubRegistry{false: {"a"}, true: {"a"}}
+	nw := stubNetwork{
+		"a": stubRunner(func() ([]byte, error) { return nil, IncorrectPartitionError{} }),
+	}
+	slow := WithRetryPolicy(RetryPolicy{MaxAttempts: 5, Backoff: time.Minute})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	r, err := New(ctx, "", nil, WithEndpointRegistry(reg), WithNetwork(nw), slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	start := time.Now()
+	if _, err := r.Run(ctx, 5, nil); err != (IncorrectPartitionError{}) {
+		t.Fatal("unexpected", err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Fatal("retry ignored deadline", time.Since(start))
+	}
+}
+
+func TestRedirectBackoff(t *testing.T) {
+	reg := stubRegistry{false: {"a", "b"}, true: {"a", "b"}}
+	nw := stubNetwork{
+		"a": stubRunner(func() ([]byte, error) { return nil, IncorrectPartitionError{Owner: "b"} }),
+		"b": stubRunner(func() ([]byte, error) { return nil, IncorrectPartitionError{Owner: "a"} }),
+	}
+	policy := WithRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: 100 * time.Millisecond})
+	ctx := context.Background()
+
+	r, err := New(ctx, "", nil, WithEndpointRegistry(reg), WithNetwork(nw), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	start := time.Now()
+	if _, err := r.Run(ctx, 5, nil); err == nil {
+		t.Fatal("unexpected success")
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("repeated redirects did not back off", time.Since(start))
+	}
+}
+
+// stubRegistry returns a fixed list based on the refresh flag
+type stubRegistry map[bool][]string
+
+func (s stubRegistry) RegisterEndpoint(ctx context.Context, addr string) (io.Closer, error) {
+	return stubRunner(nil), nil
+}
+
+func (s stubRegistry) ListEndpoints(ctx context.Context, refresh bool) ([]string, error) {
+	return s[refresh], nil
+}
+
+// stubNetwork routes requests to the runners in the map
+type stubNetwork map[string]stubRunner
+
+func (s stubNetwork) DialClient(ctx context.Context, addr string) (RunCloser, error) {
+	return s[addr], nil
+}
+
+func (s stubNetwork) RegisterServer(ctx context.Context, addr string, handler Runner) (io.Closer, error) {
+	return stubRunner(nil), nil
+}
+
+type stubRunner func() ([]byte, error)
+
+func (s stubRunner) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+	return s()
+}
+
+func (s stubRunner) Close() error {
+	return nil
+}
+
+func TestWatchEndpoints(t *testing.T) {
+	reg := &stubWatcher{stubRegistry{false: {"a"}, true: {"a"}}, make(chan []string, 1)}
+	nw := stubNetwork{
+		"a": stubRunner(func() ([]byte, error) { return []byte("a"), nil }),
+		"b": stubRunner(func() ([]byte, error) { return []byte("b"), nil }),
+	}
+	ctx := context.Background()
+
+	reg.ch <- []string{"b"}
+	r, err := New(ctx, "", nil, WithEndpointRegistry(reg), WithNetwork(nw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if res, err := r.Run(ctx, 5, nil); err != nil || string(res) != "b" {
+		t.Fatal("unexpected", string(res), err)
+	}
+
+	reg.ch <- []string{"a"}
+	for start := time.Now(); time.Since(start) < time.Second; {
+		if res, err := r.Run(ctx, 5, nil); err == nil && string(res) == "a" {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("watched change not picked up")
+}
+
+type stubWatcher struct {
+	stubRegistry
+	ch chan []string
+}
+
+func (s *stubWatcher) WatchEndpoints(ctx context.Context) (<-chan []string, error) {
+	result := make(chan []string)
+	go func() {
+		defer close(result)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case list := <-s.ch:
+				result <- list
+			}
+		}
+	}()
+	return result, nil
+}
+
+func TestWatchEndpointsFallback(t *testing.T) {
+	defer func(d time.Duration) { watchTimeout = d }(watchTimeout)
+	watchTimeout = 10 * time.Millisecond
+
+	nw := stubNetwork{
+		"a": stubRunner(func() ([]byte, error) { return []byte("a"), nil }),
+		"b": stubRunner(func() ([]byte, error) { return []byte("b"), nil }),
+	}
+	ctx := context.Background()
+
+	silent := func(ctx context.Context) <-chan []string {
+		return make(chan []string)
+	}
+	closing := func(ctx context.Context) <-chan []string {
+		ch := make(chan []string, 1)
+		ch <- []string{"b"}
+		close(ch)
+		return ch
+	}
+
+	for name, watch := range map[string]func(context.Context) <-chan []string{"silent": silent, "closing": closing} {
+		reg := funcWatcher{stubRegistry{false: {"a"}, true: {"a"}}, watch}
+		r, err := New(ctx, "", nil, WithEndpointRegistry(reg), WithNetwork(nw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		ok := false
+		for start := time.Now(); !ok && time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+			res, err := r.Run(ctx, 5, nil)
+			ok = err == nil && string(res) == "a"
+		}
+		if !ok {
+			t.Error(name, "did not fall back to polling")
+		}
+	}
+}
+
+type funcWatcher struct {
+	stubRegistry
+	watch func(ctx context.Context) <-chan []string
+}
+
+func (f funcWatcher) WatchEndpoints(ctx context.Context) (<-chan []string, error) {
+	return f.watch(ctx), nil
+}
+
+func TestWatchEndpointMetadata(t *testing.T) {
+	watch := func(ctx context.Context) <-chan []string {
+		ch := make(chan []string, 1)
+		ch <- []string{"a"}
+		go func() {
+			<-ctx.Done()
+			close(ch)
+		}()
+		return ch
+	}
+	reg := &metadataWatcher{funcWatcher: funcWatcher{stubRegistry{}, watch}}
+	nw := stubNetwork{"a": stubRunner(func() ([]byte, error) { return []byte("a"), nil })}
+	pick := func(ctx context.Context, list []Endpoint, hash uint64) string {
+		return list[0].Addr
+	}
+	ctx := context.Background()
+
+	r, err := New(ctx, "", nil, WithEndpointRegistry(reg), WithNetwork(nw), WithEndpointPicker(pick))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for kk := 0; kk < 5; kk++ {
+		if res, err := r.Run(ctx, 5, nil); err != nil || string(res) != "a" {
+			t.Fatal("unexpected", string(res), err)
+		}
+	}
+	if n := atomic.LoadInt32(&reg.calls); n != 1 {
+		t.Fatal("watched metadata not cached", n)
+	}
+}
+
+type metadataWatcher struct {
+	funcWatcher
+	calls int32
+}
+
+func (m *metadataWatcher) RegisterEndpointMetadata(ctx context.Context, ep Endpoint) (io.Closer, error) {
+	return stubRunner(nil), nil
+}
+
+func (m *metadataWatcher) ListEndpointMetadata(ctx context.Context, refresh bool) ([]Endpoint, error) {
+	atomic.AddInt32(&m.calls, 1)
+	return []Endpoint{{Addr: "a", Zone: "z"}}, nil
+}
+
+func TestLocalShortCircuit(t *testing.T) {
+	reg := stubRegistry{false: {"a"}, true: {"a"}}
+	nw := stubNetwork{
+		"a": stubRunner(func() ([]byte, error) { return []byte("network"), nil }),
+	}
+	local := stubRunner(func() ([]byte, error) { return []byte("local"), nil })
+	ctx := context.Background()
+
+	cases := map[bool]string{true: "local", false: "network"}
+	for enabled, expected := range cases {
+		r, err := New(ctx, "a", local, WithEndpointRegistry(reg), WithNetwork(nw), WithLocalShortCircuit(enabled))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		if res, err := r.Run(ctx, 5, nil); err != nil || string(res) != expected {
+			t.Error("unexpected", enabled, string(res), err)
+		}
+	}
+}
+
+func TestEpochHeader(t *testing.T) {
+	reg := versionedRegistry{stubRegistry{false: {"a"}, true: {"a"}}, 7}
+	seen := &headerRecorder{}
+	ctx := context.Background()
+
+	r, err := New(ctx, "", nil, WithEndpointRegistry(reg), WithNetwork(singleNetwork{seen}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, err := r.Run(ctx, 5, nil); err != nil || seen.header.epoch != 7 {
+		t.Fatal("unexpected epoch for Run", seen.header, err)
+	}
+	seen.header = header{}
+	if _, err := r.RunBatch(ctx, []Item{{Hash: 5}}); err != nil || seen.header.epoch != 7 {
+		t.Fatal("unexpected epoch for RunBatch", seen.header, err)
+	}
+}
+
+func TestForwardBatch(t *testing.T) {
+	list := []string{"one", "two"}
+	reg := stubRegistry{false: list, true: list}
+	seen := &headerRecorder{}
+	handler := stubRunner(func() ([]byte, error) { return []byte("one"), nil })
+	ctx := context.Background()
+
+	opts := []Option{WithEndpointRegistry(reg), WithNetwork(singleNetwork{seen}), WithForwarding(1)}
+	r, err := New(ctx, "one", handler, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	items := []Item{}
+	for hash := uint64(0); len(items) < 3; hash++ {
+		if NewPicker()(ctx, list, hash) == "two" {
+			items = append(items, Item{Hash: hash})
+		}
+	}
+
+	// the misrouted items are forwarded together
+	receiver := safe{r.(*state)}
+	if _, err := receiver.RunBatch(ctx, items); err != nil {
+		t.Fatal(err)
+	}
+	if seen.batches != 1 || seen.items != len(items) || seen.header.hops != 1 {
+		t.Fatal("unexpected forwarded batches", seen.batches, seen.items, seen.header)
+	}
+
+	// forwarded requests are not forwarded again
+	hops := header{hops: 1}.attach(ctx)
+	if _, err := receiver.RunBatch(hops, items); err != nil || seen.batches != 1 {
+		t.Fatal("request forwarded again", seen.batches, err)
+	}
+}
+
+// versionedRegistry adds a fixed version to a stub registry
+type versionedRegistry struct {
+	stubRegistry
+	version uint64
+}
+
+func (v versionedRegistry) ListVersionedEndpoints(ctx context.Context, refresh bool) ([]string, uint64, error) {
+	return v.stubRegistry[refresh], v.version, nil
+}
+
+// singleNetwork routes all requests to the same runner
+type singleNetwork struct {
+	RunCloser
+}
+
+func (s singleNetwork) DialClient(ctx context.Context, addr string) (RunCloser, error) {
+	return s.RunCloser, nil
+}
+
+func (s singleNetwork) RegisterServer(ctx context.Context, addr string, handler Runner) (io.Closer, error) {
+	return stubRunner(nil), nil
+}
+
+// headerRecorder records the header of the last request and counts
+// the batches and the items in them
+type headerRecorder struct {
+	header         header
+	batches, items int
+}
+
+func (r *headerRecorder) Run(ctx context.Context, hash uint64, input []byte) ([]byte, error) {
+	r.header = headerOf(ctx)
+	return nil, nil
+}
+
+func (r *headerRecorder) RunBatch(ctx context.Context, items []Item) ([]Result, error) {
+	r.header = headerOf(ctx)
+	r.batches++
+	r.items += len(items)
+	return make([]Result, len(items)), nil
+}
+
+func (r *headerRecorder) Close() error {
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"io"
+)
+
+// NewStaticRegistry returns a registry with a fixed list of
+// endpoints.
+//
+// RegisterEndpoint is a no-op: the list is expected to include
+// every server in the cluster.
+func NewStaticRegistry(list []string) EndpointRegistry {
+	return staticreg(append([]string(nil), list...))
+}
+
+type staticreg []string
+
+func (s staticreg) RegisterEndpoint(ctx context.Context, addr string) (io.Closer, error) {
+	return nopcloser{}, nil
+}
+
+func (s staticreg) ListEndpoints(ctx context.Context, refresh bool) ([]string, error) {
+	return []string(s), nil
+}
+
+func (s staticreg) externalMembership() bool {
+	return true
+}
+
+// externalMembership is implemented by registries whose list of
+// endpoints is managed externally, such as NewStaticRegistry, which
+// keep listing endpoints after they deregister.
+type externalMembership interface {
+	externalMembership() bool
+}
+
+func isExternalMembership(r EndpointRegistry) bool {
+	e, ok := r.(externalMembership)
+	return ok && e.externalMembership()
+}
+
+type nopcloser struct{}
+
+func (nopcloser) Close() error {
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"hash/crc32"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// NewWeightedPicker returns a picker which uses a weighted highest
+// random weight algorithm: each endpoint gets a share of the hashes
+// proportional to its weight.
+//
+// This picker must be configured via WithEndpointPicker.
+//
+// See https://en.wikipedia.org/wiki/Rendezvous_hashing#Weighted_rendezvous_hash
+func NewWeightedPicker() func(ctx context.Context, list []Endpoint, hash uint64) string {
+	return weightedHRWPicker
+}
+
+func weightedHRWPicker(ctx context.Context, list []Endpoint, hash uint64) string {
+	var pick string
+	var score float64
+
+	key := []byte(strconv.FormatUint(hash, 16) + "-")
+	for _, candidate := range list {
+		h := fnv.New64a()
+		checkWrite(h.Write(key))
+		checkWrite(h.Write([]byte(candidate.Addr)))
+
+		// u is uniformly distributed in (0, 1)
+		u := (float64(mix(h.Sum64())>>11) + 0.5) / (1 << 53)
+		if x := candidate.weight() / -math.Log(u); x > score {
+			pick, score = candidate.Addr, x
+		}
+	}
+	return pick
+}
+
+// NewWeightedHashRing returns a picker which uses a consistent
+// hashing scheme where the number of points each endpoint has on
+// the ring is proportional to its weight.
+//
+// This picker must be configured via WithEndpointPicker.
+func NewWeightedHashRing() func(ctx context.Context, list []Endpoint, hash uint64) string {
+	ring := &weightedRing{factor: 1000}
+	return ring.Pick
+}
+
+type weightedRing struct {
+	factor int // number of points for the endpoint with the max weight
+
+	sync.Mutex
+	weights map[string]float64
+	hashes  []uint32          // sorted
+	lookup  map[uint32]string // hash => endpoint
+}
+
+func (w *weightedRing) Pick(ctx context.Context, list []Endpoint, hash uint64) string {
+	if len(list) == 0 {
+		return ""
+	}
+
+	hashes, lookup := w.local(ctx).getSortedHashes(list)
+	return lookup[hashes[ringIndex(hashes, hash)]]
+}
+
+// local returns the ring to use with the context (see pickerState).
+func (w *weightedRing) local(ctx context.Context) *weightedRing {
+	create := func() interface{} { return &weightedRing{factor: w.factor} }
+	if ring := localState(ctx, w, create); ring != nil {
+		return ring.(*weightedRing)
+	}
+	return w
+}
+
+func (w *weightedRing) getSortedHashes(list []Endpoint) ([]uint32, map[uint32]string) {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.changed(list) {
+		w.weights = map[string]float64{}
+		for _, ep := range list {
+			w.weights[ep.Addr] = ep.weight()
+		}
+		w.hashes, w.lookup = w.calculateSortedHashes()
+	}
+	return w.hashes, w.lookup
+}
+
+func (w *weightedRing) changed(list []Endpoint) bool {
+	if len(list) != len(w.weights) {
+		return true
+	}
+	for _, ep := range list {
+		if weight, ok := w.weights[ep.Addr]; !ok || weight != ep.weight() {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *weightedRing) calculateSortedHashes() ([]uint32, map[uint32]string) {
+	max := 0.0
+	for _, weight := range w.weights {
+		max = math.Max(max, weight)
+	}
+
+	hashes := []uint32{}
+	dict := map[uint32]string{}
+	for addr, weight := range w.weights {
+		points := int(math.Ceil(float64(w.factor) * weight / max))
+		for ff := 0; ff < points; ff++ {
+			data := []byte(strconv.Itoa(ff*12394+1) + "-" + addr)
+			hash := crc32.ChecksumIEEE(data)
+			if existing, ok := dict[hash]; ok && existing < addr {
+				continue
+			}
+			if _, ok := dict[hash]; !ok {
+				hashes = append(hashes, hash)
+			}
+			dict[hash] = addr
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	return hashes, dict
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import "context"
+
+// NewZonePicker returns a picker which restricts the endpoints to
+// those in the provided zone when there are any and falls back to
+// all the endpoints otherwise. The actual pick is made by the inner
+// picker (such as NewWeightedPicker).
+//
+// This picker must be configured via WithEndpointPicker. The zone of
+// the local endpoint is specified via WithEndpointMetadata.
+//
+// Routers in different zones pick different owners for the same
+// hash, so an endpoint accepts a request if it is the owner within
+// its own zone or the owner across all zones.
+func NewZonePicker(zone string, inner func(ctx context.Context, list []Endpoint, hash uint64) string) func(ctx context.Context, list []Endpoint, hash uint64) string {
+	own := newZoneStates()
+	states := func(ctx context.Context) *zoneStates {
+		if s := localState(ctx, own, func() interface{} { return newZoneStates() }); s != nil {
+			return s.(*zoneStates)
+		}
+		return own
+	}
+
+	return func(ctx context.Context, list []Endpoint, hash uint64) string {
+		s := states(ctx)
+		pickZoned := func(zoned []Endpoint) string {
+			return inner(withPickerState(ctx, s.zoned), zoned, hash)
+		}
+		pickGlobal := func() string {
+			return inner(withPickerState(ctx, s.global), list, hash)
+		}
+
+		if self, ok := ctx.Value(ownerCheckKey{}).(string); ok {
+			for _, ep := range list {
+				if ep.Addr == self && pickZoned(inZone(list, ep.Zone)) == self {
+					return self
+				}
+			}
+			return pickGlobal()
+		}
+
+		if local := inZone(list, zone); len(local) > 0 {
+			return pickZoned(local)
+		}
+		return pickGlobal()
+	}
+}
+
+// zoneStates holds separate states for the inner picker when used
+// with the endpoints of a zone and with all the endpoints so that
+// stateful pickers (such as NewWeightedHashRing) do not rebuild their
+// state when alternating between the two lists.
+type zoneStates struct {
+	zoned, global *pickerState
+}
+
+func newZoneStates() *zoneStates {
+	return &zoneStates{&pickerState{}, &pickerState{}}
+}
+
+func inZone(list []Endpoint, zone string) []Endpoint {
+	result := []Endpoint(nil)
+	for _, ep := range list {
+		if ep.Zone == zone {
+			result = append(result, ep)
+		}
+	}
+	return result
+}
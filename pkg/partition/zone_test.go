@@ -0,0 +1,70 @@
+// Copyright (C) 2019 rameshvk. All rights reserved.
+// Use of this source code is governed by a MIT-style license
+// that can be found in the LICENSE file.
+
+package partition
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestZonePicker(t *testing.T) {
+	ctx := context.Background()
+	list := []Endpoint{
+		{Addr: "a1", Zone: "a"},
+		{Addr: "a2", Zone: "a"},
+		{Addr: "b1", Zone: "b"},
+	}
+	pickA := NewZonePicker("a", NewWeightedPicker())
+	pickB := NewZonePicker("b", NewWeightedPicker())
+	pickC := NewZonePicker("c", NewWeightedPicker())
+
+	for hash := uint64(0); hash < 1000; hash++ {
+		a, b, c := pickA(ctx, list, hash), pickB(ctx, list, hash), pickC(ctx, list, hash)
+		if a != "a1" && a != "a2" {
+			t.Fatal("zone a routed across zones", hash, a)
+		}
+		if b != "b1" {
+			t.Fatal("zone b routed across zones", hash, b)
+		}
+
+		for _, owner := range []string{a, b, c} {
+			check := context.WithValue(ctx, ownerCheckKey{}, owner)
+			if x := pickC(check, list, hash); x != owner {
+				t.Fatal("owner check failed", hash, owner, x)
+			}
+		}
+	}
+}
+
+func TestZonePickerState(t *testing.T) {
+	ctx := context.Background()
+	list := []Endpoint{
+		{Addr: "a1", Zone: "a"},
+		{Addr: "a2", Zone: "a"},
+		{Addr: "b1", Zone: "b"},
+	}
+
+	// inner rebuilds its state whenever the list changes
+	builds := 0
+	inner := func(ctx context.Context, list []Endpoint, hash uint64) string {
+		last := localState(ctx, "last", func() interface{} { return &[]Endpoint{} }).(*[]Endpoint)
+		if !reflect.DeepEqual(*last, list) {
+			builds++
+			*last = list
+		}
+		return list[int(hash)%len(list)].Addr
+	}
+
+	pick := NewZonePicker("a", inner)
+	check := context.WithValue(ctx, ownerCheckKey{}, "a2")
+	for hash := uint64(0); hash < 100; hash++ {
+		pick(ctx, list, hash)
+		pick(check, list, hash)
+	}
+	if builds != 2 {
+		t.Fatal("inner picker state rebuilt", builds)
+	}
+}